@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
+	"os"
 	"runtime"
-	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -13,62 +16,203 @@ import (
 
 // TODO Are we measuring the latency of failed requests correctly, taking coordinated omission into account?
 
+// What to do with a new request when maxConcurrent requests are already in flight.
+type concurrencyMode int
+
+const (
+	concurrencyModeFail  concurrencyMode = iota // Immediately mark the new request as error.
+	concurrencyModeQueue                        // Hold the new request back until a slot frees up. The time spent waiting counts towards its latency.
+)
+
+// Settings that stay the same across all benchmarks in a run.
+type benchmarkConfig struct {
+	host              string // The target as given on the command line.
+	ipv4              net.IP
+	port              int
+	seconds           int           // Duration of the measured part of each benchmark.
+	timeout           time.Duration // Max time from the planned time of a request until the full response.
+	connectTimeout    time.Duration // Max time to establish a connection. If 0, only the total timeout applies.
+	firstByteTimeout  time.Duration // Max time from writing the full request until the first byte of the response. If 0, only the total timeout applies.
+	maxConcurrent     int
+	concurrencyMode   concurrencyMode
+	warmupSeconds     int           // Duration of the warmup before the measured part of each benchmark. Requests sent during warmup are excluded from the results.
+	warmupRps         int           // Rate during warmup. If 0, the rps of the benchmark itself is used.
+	connMaxRequests   int           // Close a keep-alive connection after this many requests. If 0, there is no limit.
+	connMaxAge        time.Duration // Close a keep-alive connection once it is this old. If 0, there is no limit.
+	connChurn         float64       // Fraction of keep-alive connections to close at random after each request.
+	connCloseMode     connCloseMode
+	retryMaxAttempts  int             // Max number of times a request is issued, including the first time.
+	retryOn           retryKinds      // The kinds of server-closed connections after which a request is retried.
+	retryAsError      bool            // Count each request that needed a retry as an error.
+	histSigFigs       int             // Number of significant decimal digits that latency histograms keep.
+	percentiles       []float64       // Latency percentiles to report, in increasing order.
+	slo               slo             // The conditions a benchmark must meet to pass.
+	hdrFile           string          // If set, the latency histogram is written to this file in the HdrHistogram percentile distribution format.
+	hdrLogFile        string          // If set, per-second latency histograms are written to this file in the HdrHistogram interval log format.
+	probeInterval     time.Duration   // Time between probes while waiting for the target to recover after a failed benchmark.
+	recoveryFactor    float64         // The target has recovered once probe latency is within this factor of the baseline.
+	recoveryMaxWait   time.Duration   // Give up waiting for the target to recover after this long. If 0, do not wait.
+	earlyAbort        bool            // Stop a benchmark as soon as the SLO is certain to fail.
+	adaptive          bool            // Stop a benchmark once its percentiles have converged. The seconds setting is then the max duration.
+	minSeconds        int             // Min duration of the measured part of an adaptive benchmark.
+	adaptiveTolerance float64         // An adaptive benchmark has converged once the estimates of each percentile are within this fraction of each other.
+	output            string          // Either "text" or "json".
+	resultsFile       string          // With json output, the result of each benchmark of a search or sweep is appended to this file as a JSON line.
+	timeSeriesFile    string          // If set, per-second samples of the counters are appended to this file, as JSON lines if it ends in .json or .jsonl, and CSV otherwise.
+	dashboard         *dashboard      // If set, progress is shown on a full-screen dashboard instead of status lines.
+	requests          *requestsWriter // Writes the per-request results of each benchmark. If nil, they are not kept.
+	requestsFile      string          // The file the per-request results of the benchmark are written to.
+	maxSendLag        time.Duration   // The benchmark is invalid if the p99 of how late hlg got to requests exceeds this. If 0, it is not checked.
+}
+
 type Benchmark struct {
+	reqsInFlight int64 // Number of requests holding a concurrency slot, across all workers. Only accessed atomically.
+	reqsQueued   int64 // Number of requests waiting for a concurrency slot, across all workers. Only accessed atomically.
+	aborted      int32 // 1 if the benchmark was stopped early because the SLO was certain to fail. Only accessed atomically.
+	benchmarkConfig
 	payload            *reqPayload
 	addr               unix.SockaddrInet4
-	seconds            int
-	timeout            time.Duration
 	rps                int
-	maxConcurrent      int
 	verbose            bool
 	ep                 *executionPlan
 	startTime          time.Time
 	startTimeMonotonic int64
 	endTime            time.Time
 	done               bool
+	abortViolations    []string // The SLO conditions that were certain to fail.
+	measuredReqs       int      // Number of requests planned for the measured part of the benchmark.
+	conv               *convergence
+	intervals          []timeSeriesSample // Per-second samples of the counters.
+	prevIntervalStats  *stats             // Snapshot of the counters at the end of the previous interval.
+	prevIntervalEnd    time.Duration      // Time since the start of the benchmark at the end of the previous interval.
+	prevHost           *hostCounters      // Counters of the client host at the end of the previous interval. Nil if /proc could not be read.
+	startGC            *gcCounters        // GC counters of hlg before the workers started.
+	endGC              *gcCounters        // GC counters of hlg after the workers stopped.
 	workerCount        int
 	workers            []*benchmarkWorker
+	workersDone        sync.WaitGroup // Done once every worker has closed its connections and returned.
 }
 
 type benchmarkWorker struct {
 	benchmark      *Benchmark
 	workerID       int
-	reqsInProgress map[int]*request // Find a request item from a file descriptor. These are the requests currently in flight.
-	//reqsInProgressTimeouts timeoutHeap
-	connRb              *ringbuffer // Ring buffer used to store client connections that can be reused if using HTTP keep-alive.
-	epollfd             int
-	timerfdReqs         int // Timer file descriptor for scheduling requests.
-	timerfdTimeout      int // Timer file descriptor for timeouts.
-	timerfdTimeoutArmed bool
-	stats               *stats
-	buf                 []byte
+	reqsInProgress map[int]*request   // Find a request item from a file descriptor. These are the requests currently in flight.
+	timeouts       timeoutHeap        // Deadlines of the requests of this worker.
+	connRb         *ringbuffer        // Ring buffer used to store client connections that can be reused if using HTTP keep-alive.
+	reqsQueued     []*request         // Requests waiting for a concurrency slot, in the order they were planned.
+	conns          map[int]*connState // Find the state of an open connection from its file descriptor.
+	rand           *rand.Rand
+	epollfd        int
+	timerfdReqs    int    // Timer file descriptor for scheduling requests.
+	timerfdTimeout int    // Timer file descriptor for timeouts.
+	stats          *stats // Stats for requests in the measured part of the benchmark.
+	warmupStats    *stats // Stats for requests sent during warmup.
+	buf            []byte
+	snapshot       workerSnapshot
+}
+
+// How often a worker publishes its snapshot.
+const snapshotInterval = 100 * time.Millisecond
+
+// A copy of the counters of a worker, which the worker publishes from time to time for the main goroutine to read
+// while the worker keeps changing its own.
+type workerSnapshot struct {
+	mu          sync.Mutex
+	stats       *stats
+	warmupStats *stats
+	connsAlive  int       // Connections open, with a request in flight or idle.
+	at          time.Time // When the snapshot was last published.
 }
 
 type BenchmarkResult struct {
-	startedRate float64
-	errors      uint
-	recvd       uint
-	p99d9       time.Duration
-	p99d99      time.Duration
-	p99d999     time.Duration
-	max         time.Duration
+	startedRate     float64
+	connsOpenedRate float64
+	connsClosedRate float64
+	recvdRate       float64
+	started         uint
+	errors          uint
+	recvd           uint
+	max             time.Duration
+	percentiles     []float64 // The latency percentiles to report, in increasing order.
+	phases          [phaseCount]phaseResult
+	hist            *histogram  // Merged latency histogram of all workers, in microseconds.
+	stats           *stats      // Merged stats of all workers for the measured part of the benchmark.
+	sloPass         bool        // Whether all conditions of the SLO held.
+	sloViolations   []string    // Descriptions of the SLO conditions that did not hold.
+	aborted         bool        // Whether the benchmark was stopped early because the SLO was certain to fail.
+	seconds         float64     // Duration of the measured part of the benchmark.
+	sendLag         phaseResult // How late the workers got to requests after their planned time.
+	saturated       bool        // Whether hlg itself fell behind, so that the latencies include its own delays and the result is invalid.
+	host            *hostLoad   // Load on the client host during the measured part. Nil if /proc could not be read.
+}
+
+// Gets the latency at the given percentile. The 100th percentile is the exact max rather than the histogram's estimate.
+func (r *BenchmarkResult) latencyAtPercentile(p float64) time.Duration {
+	if p >= 100 {
+		return r.max
+	}
+
+	if r.hist == nil {
+		return 0
+	}
+
+	// The histogram gives the highest value in the bucket, which may be above the exact max.
+	d := time.Duration(r.hist.valueAtPercentile(p)) * time.Microsecond
+	if d > r.max {
+		d = r.max
+	}
+
+	return d
+}
+
+// Number of samples above the given percentile, i.e. the samples that the estimate of the percentile rests on.
+func (r *BenchmarkResult) tailSamples(p float64) int64 {
+	if r.hist == nil {
+		return 0
+	}
+
+	// Round away floating point error, such as 1000 samples at p99.9 giving 0.9999999.
+	return int64(float64(r.hist.totalCount)*(100-p)/100 + 1e-6)
 }
 
-func NewBenchmark(payload *reqPayload, ipv4 net.IP, port int, seconds int, rps int, timeout time.Duration, maxConcurrent int, verbose bool) *Benchmark {
+// Fraction of started requests that resulted in an error.
+func (r *BenchmarkResult) errorRate() float64 {
+	if r.started == 0 {
+		return 0
+	}
+
+	return float64(r.errors) / float64(r.started)
+}
+
+func NewBenchmark(payload *reqPayload, cfg benchmarkConfig, rps int, verbose bool) *Benchmark {
 	workerCount := runtime.NumCPU()
+
+	warmupRps := cfg.warmupRps
+	if warmupRps == 0 {
+		warmupRps = rps
+	}
+
 	b := &Benchmark{
-		workerCount:   workerCount,
-		payload:       payload,
-		seconds:       seconds,
-		timeout:       timeout,
-		rps:           rps,
-		maxConcurrent: maxConcurrent,
-		verbose:       verbose,
-		ep:            newExecutionPlan(rps, seconds, workerCount),
+		benchmarkConfig: cfg,
+		workerCount:     workerCount,
+		payload:         payload,
+		rps:             rps,
+		verbose:         verbose,
+		ep:              newExecutionPlan(rps, cfg.seconds, warmupRps, cfg.warmupSeconds, workerCount),
 	}
 
-	b.addr = unix.SockaddrInet4{Port: port}
-	copy(b.addr.Addr[:], ipv4)
+	b.addr = unix.SockaddrInet4{Port: cfg.port}
+	copy(b.addr.Addr[:], cfg.ipv4)
+
+	for i := range b.ep.reqs {
+		if !b.ep.reqs[i].warmup {
+			b.measuredReqs++
+		}
+	}
+
+	if cfg.adaptive {
+		b.conv = newConvergence(cfg.percentiles, cfg.adaptiveTolerance)
+	}
 
 	return b
 }
@@ -83,28 +227,75 @@ func (b *Benchmark) Start() (r BenchmarkResult, err error) {
 	}
 	b.startTimeMonotonic = unix.TimespecToNsec(t)
 
+	// The load on the client host is left out of the results where /proc cannot be read.
+	if host, err := readHostCounters(); err == nil {
+		b.prevHost = host
+	}
+	b.startGC = readGCCounters()
+
+	liveMetrics.benchmarkStarted(b.rps)
+	defer liveMetrics.benchmarkFinished()
+
 	for i := 0; i < b.workerCount; i++ {
 		w := &benchmarkWorker{
 			benchmark:      b,
 			workerID:       i,
 			reqsInProgress: make(map[int]*request),
 			connRb:         &ringbuffer{},
+			conns:          make(map[int]*connState),
+			rand:           rand.New(rand.NewSource(time.Now().UnixNano() + int64(i))),
 			stats:          newStats(),
+			warmupStats:    newStats(),
 			buf:            make([]byte, 32*1024),
 		}
+		w.stats.hist = newHistogram(histogramLowestMicros, histogramHighestMicros, b.histSigFigs)
+		w.warmupStats.hist = newHistogram(histogramLowestMicros, histogramHighestMicros, b.histSigFigs)
+		w.stats.sendLag = newHistogram(histogramLowestMicros, histogramHighestMicros, b.histSigFigs)
+		w.warmupStats.sendLag = newHistogram(histogramLowestMicros, histogramHighestMicros, b.histSigFigs)
+
+		w.snapshot.stats = newStats()
+		w.snapshot.warmupStats = newStats()
+		w.publishSnapshot()
 
 		b.workers = append(b.workers, w)
 
-		go w.startWorker()
+		b.workersDone.Add(1)
+		go func() {
+			defer b.workersDone.Done()
+			w.startWorker()
+			w.publishSnapshot() // Counts the requests dropped when the worker closed its connections.
+		}()
 	}
 
 	for {
 		time.Sleep(1 * time.Second)
-		if b.elapsed() > time.Duration(b.seconds)*time.Second {
+		b.recordInterval()
+		if b.elapsed() > time.Duration(b.warmupSeconds+b.seconds)*time.Second {
 			break
 		}
-		if b.verbose {
-			b.printStatus()
+		b.showStatus()
+
+		if b.inWarmup() || (!b.earlyAbort && !b.adaptive) {
+			continue
+		}
+		s := b.totalStats()
+
+		// Check every second whether the SLO has already failed, to not waste time on the rest of the benchmark.
+		if b.earlyAbort {
+			b.abortViolations = b.slo.certainViolations(s, b.measuredReqs)
+			if len(b.abortViolations) > 0 {
+				atomic.StoreInt32(&b.aborted, 1)
+				break
+			}
+		}
+
+		if b.adaptive {
+			if b.measuredElapsed() >= time.Duration(b.minSeconds)*time.Second && b.conv.converged(s.hist) {
+				if b.verbose {
+					fmt.Printf("Percentiles converged after %.0fs\n", b.measuredElapsed().Seconds())
+				}
+				break
+			}
 		}
 	}
 
@@ -112,16 +303,36 @@ func (b *Benchmark) Start() (r BenchmarkResult, err error) {
 	b.done = true
 
 	// Wait until final requests have timed out
-	for i := 0; b.reqsConcurrent() > 0; i++ {
+	for i := 1; b.reqsConcurrent() > 0; i++ {
 		time.Sleep(100 * time.Millisecond)
-		if i%10 == 0 && b.verbose {
-			b.printStatus()
+		if i%10 == 0 {
+			b.recordInterval()
+			b.showStatus()
 		}
 	}
+	// The workers may still be closing connections and finishing requests, which must not change after this.
+	b.workersDone.Wait()
+	b.endGC = readGCCounters()
+	b.recordInterval()
 
-	b.ep.writeResultsFile("latencies.csv")
+	if b.requests != nil {
+		b.requests.write(b.requestsFile, b.rps, b.startTime, b.ep.reqs)
+	}
+
+	if b.timeSeriesFile != "" {
+		err = appendTimeSeries(b.timeSeriesFile, b.intervals, b.percentiles)
+		if err != nil {
+			return
+		}
+	}
 
 	r = b.calculateResult()
+
+	err = b.writeHistogramFiles()
+	if err != nil {
+		return
+	}
+
 	if b.verbose {
 		b.printSummary(r)
 	}
@@ -173,8 +384,14 @@ func (b *benchmarkWorker) eventLoop() (err error) {
 		// TODO if there are already known overdue requests to send, then do use the timerfd to schedule next event and do not let EpollWait block, but still run it in order to process any ongoing traffic
 
 		// Wait until one or more events occur.
+		// Poll more often while requests are queued, as a concurrency slot may be freed up by another worker.
+		waitMs := 100
+		if len(b.reqsQueued) > 0 {
+			waitMs = 1
+		}
+
 		var nevents int
-		nevents, err = unix.EpollWait(b.epollfd, events[:], 100)
+		nevents, err = unix.EpollWait(b.epollfd, events[:], waitMs)
 		if err != nil {
 			err = fmt.Errorf("epoll_wait failed: %v", error(err))
 			panic(err)
@@ -184,6 +401,19 @@ func (b *benchmarkWorker) eventLoop() (err error) {
 			fd := int(events[i].Fd)
 			curReq := b.reqsInProgress[fd]
 
+			// Handle connections with a socket error, such as when the target refused or reset them. Connections that the
+			// target closed gracefully are handled below.
+			if events[i].Events&(unix.EPOLLERR|unix.EPOLLHUP|unix.EPOLLRDHUP) != 0 && fd != b.timerfdReqs && fd != b.timerfdTimeout {
+				if sockErr := socketError(fd); sockErr != nil {
+					err = b.handleSocketError(fd, curReq, sockErr)
+					if err != nil {
+						panic(err)
+					}
+
+					continue
+				}
+			}
+
 			// Handle connections that got closed.
 			if events[i].Events&unix.EPOLLHUP != 0 || events[i].Events&unix.EPOLLRDHUP != 0 {
 				err = b.handleConnectionClosed(fd, curReq)
@@ -231,7 +461,10 @@ func (b *benchmarkWorker) eventLoop() (err error) {
 					panic(err)
 				}
 
-				b.writeRequest(curReq, fd)
+				err = b.writeRequest(curReq, fd)
+				if err != nil {
+					panic(err)
+				}
 
 				continue
 			}
@@ -249,12 +482,24 @@ func (b *benchmarkWorker) eventLoop() (err error) {
 			}
 		}
 
-		if b.benchmark.done && len(b.reqsInProgress) == 0 {
+		err = b.issueQueuedRequests()
+		if err != nil {
+			panic(err)
+		}
+
+		if time.Since(b.snapshot.at) >= snapshotInterval {
+			b.publishSnapshot()
+		}
+
+		if b.benchmark.done && len(b.reqsInProgress) == 0 && len(b.reqsQueued) == 0 {
 			return
 		}
-	}
 
-	return
+		// Requests still in flight or queued are dropped by closeAllFds.
+		if b.benchmark.isAborted() {
+			return
+		}
+	}
 }
 
 func (b *benchmarkWorker) handleReqTimerTriggered() (err error) {
@@ -268,21 +513,33 @@ func (b *benchmarkWorker) handleReqTimerTriggered() (err error) {
 	if curReq == nil {
 		return // TODO this shouldn't be needed...
 	}
-	b.stats.reqsStarted++
-	/*
-		if b.benchmark.reqsConcurrent() >= b.benchmark.maxConcurrent {
-			curReq.error = true
-			b.stats.errorsTooManyConcurrent++
+	curReq.started = true
+	b.statsFor(curReq).reqsStarted++
+
+	// Getting to the request late means the event loop is falling behind, and the delay would be counted as latency
+	// of the target.
+	lag := time.Since(b.benchmark.startTime) - curReq.when
+	if lag < 0 {
+		lag = 0
+	}
+	b.statsFor(curReq).sendLag.record(int64(lag / time.Microsecond))
+
+	if b.benchmark.acquireSlot(curReq) {
+		err = b.issueRequest(curReq)
+		if err != nil {
 			return
 		}
-	*/
-	err = b.issueRequest(curReq)
-	if err != nil {
-		return
+	} else if b.benchmark.concurrencyMode == concurrencyModeQueue {
+		curReq.queued = true
+		b.reqsQueued = append(b.reqsQueued, curReq)
+		atomic.AddInt64(&b.benchmark.reqsQueued, 1)
+	} else {
+		b.fail(curReq, reasonTooManyConcurrent)
+		b.statsFor(curReq).errorsTooManyConcurrent++
 	}
 
-	if !b.timerfdTimeoutArmed {
-		err = b.scheduleNextTimeout()
+	if !curReq.error {
+		err = b.addDeadline(curReq, timeoutTotal, curReq.when+b.benchmark.timeout)
 		if err != nil {
 			return
 		}
@@ -296,92 +553,90 @@ func (b *benchmarkWorker) handleReqTimerTriggered() (err error) {
 	return
 }
 
-func (b *benchmarkWorker) scheduleNextRequest() (err error) {
-	// Schedule next request.
-	next := b.benchmark.ep.peekNext(b.workerID)
-	if b.benchmark.done || next == nil {
-		// No more requests.
-		err = timerFdSetTime(0, b.timerfdReqs)
-		return
-	}
+// Issues requests that are waiting for a concurrency slot, for as long as slots are available.
+func (b *benchmarkWorker) issueQueuedRequests() (err error) {
+	for len(b.reqsQueued) > 0 {
+		r := b.reqsQueued[0]
 
-	timeSinceBeginning := time.Now().Sub(b.benchmark.startTime)
-	timeUntilNext := next.when - timeSinceBeginning
-	if timeUntilNext < 1 {
-		timeUntilNext = 1 // 0 means never trigger, so use 1 nanosecond instead.
-	}
+		// Requests that timed out while waiting in the queue are already accounted for.
+		if !r.queued {
+			b.reqsQueued = b.reqsQueued[1:]
+			continue
+		}
 
-	err = timerFdSetTime(timeUntilNext, b.timerfdReqs)
-	return
-}
+		if !b.benchmark.acquireSlot(r) {
+			return
+		}
 
-func (b *benchmarkWorker) scheduleNextTimeout() (err error) {
-	timeSinceBeginning := time.Now().Sub(b.benchmark.startTime)
+		b.reqsQueued = b.reqsQueued[1:]
+		r.queued = false
+		atomic.AddInt64(&b.benchmark.reqsQueued, -1)
 
-	for {
-		r := b.benchmark.ep.getNextPotentialTimeoutReq(b.workerID)
-		if r == nil {
-			b.timerfdTimeoutArmed = false
-			err = timerFdSetTime(0, b.timerfdTimeout)
+		err = b.issueRequest(r)
+		if err != nil {
 			return
 		}
+	}
 
-		if r.completed || r.error {
-			continue
-		}
+	return
+}
 
-		timeUntilTimeout := (r.when + b.benchmark.timeout) - timeSinceBeginning
-		if timeUntilTimeout < 1 {
-			// Already timed out.
-			err = b.timeoutRequest(r)
-			if err != nil {
-				return
-			}
-			continue
-		}
+// The stats that the outcome of the given request should be counted in. Events not tied to a request are counted in the measured stats.
+func (b *benchmarkWorker) statsFor(r *request) *stats {
+	if r != nil && r.warmup {
+		return b.warmupStats
+	}
 
-		b.timerfdTimeoutArmed = true
-		err = timerFdSetTime(timeUntilTimeout, b.timerfdTimeout)
-		return
+	return b.stats
+}
+
+// Attempts to reserve one of the maxConcurrent slots for the given request.
+func (b *Benchmark) acquireSlot(r *request) bool {
+	if atomic.AddInt64(&b.reqsInFlight, 1) > int64(b.maxConcurrent) {
+		atomic.AddInt64(&b.reqsInFlight, -1)
+		return false
 	}
+
+	r.holdsSlot = true
+	return true
 }
 
-func (b *benchmarkWorker) handleTimeoutTimerTriggered() (err error) {
-	r := b.benchmark.ep.latestTimeoutReq[b.workerID]
-	err = b.timeoutRequest(r)
-	if err != nil {
+// Gives back the slot held by a request that is no longer in flight.
+func (b *benchmarkWorker) releaseSlot(r *request) {
+	if !r.holdsSlot {
 		return
 	}
 
-	err = b.scheduleNextTimeout()
-	return
+	r.holdsSlot = false
+	atomic.AddInt64(&b.benchmark.reqsInFlight, -1)
 }
 
-func (b *benchmarkWorker) timeoutRequest(r *request) (err error) {
-	if r != nil && !r.completed && !r.error {
-		err = unix.EpollCtl(b.epollfd, unix.EPOLL_CTL_DEL, r.socketfd, nil)
-		if err != nil {
-			err = fmt.Errorf("epoll_ctl failed on EPOLL_CTL_DEL for client socket fd %d: %v", r.socketfd, error(err))
-			return
-		}
-		unix.Close(r.socketfd)
-		delete(b.reqsInProgress, r.socketfd)
-		r.socketfd = 0
-		r.responseReader = ResponseReader{}
-		r.error = true
-		b.stats.errorsTimeout++
-		r.responseTime = time.Since(b.benchmark.startTime) - r.when
-		b.stats.recordValue(b.benchmark.timeout) // TODO maybe use a more real value instead...
+func (b *benchmarkWorker) scheduleNextRequest() (err error) {
+	// Schedule next request.
+	next := b.benchmark.ep.peekNext(b.workerID)
+	if b.benchmark.done || next == nil {
+		// No more requests.
+		err = timerFdSetTime(0, b.timerfdReqs)
+		return
+	}
+
+	timeSinceBeginning := time.Now().Sub(b.benchmark.startTime)
+	timeUntilNext := next.when - timeSinceBeginning
+	if timeUntilNext < 1 {
+		timeUntilNext = 1 // 0 means never trigger, so use 1 nanosecond instead.
 	}
 
+	err = timerFdSetTime(timeUntilNext, b.timerfdReqs)
 	return
 }
 
 func (b *benchmarkWorker) handleConnectionReadyToRead(fd int, curReq *request) (err error) {
-	var n int
-	n, err = unix.Read(fd, b.buf)
-	if err != nil {
-		err = fmt.Errorf("read error: %v", error(err))
+	n, readErr := unix.Read(fd, b.buf)
+	if readErr == unix.EAGAIN {
+		return
+	}
+	if readErr != nil {
+		err = b.handleSocketError(fd, curReq, readErr)
 		return
 	}
 
@@ -395,28 +650,49 @@ func (b *benchmarkWorker) handleConnectionReadyToRead(fd int, curReq *request) (
 		return
 	}
 
-	curReq.completed, err = curReq.responseReader.Read(b.buf[:n])
-	if err != nil {
-		curReq.error = true
-		b.stats.errorsResponseReader++
-		curReq.responseTime = time.Since(b.benchmark.startTime) - curReq.when
+	now := time.Since(b.benchmark.startTime)
+	if curReq.firstByteAt == 0 {
+		curReq.firstByteAt = now
+	}
+
+	var parseErr error
+	curReq.completed, parseErr = curReq.responseReader.Read(b.buf[:n])
+	if parseErr != nil {
+		// The rest of the response cannot be made sense of, so the connection is of no further use.
+		b.releaseSlot(curReq)
+		reason := reasonSocketOther
+		if pe, ok := parseErr.(*parseError); ok {
+			reason = pe.reason
+		}
+		b.fail(curReq, reason)
+		b.statsFor(curReq).errorsResponseReader++
+		curReq.completedAt = now
+		curReq.responseTime = now - curReq.when
+		b.statsFor(curReq).recordLatency(curReq.responseTime)
+
+		err = b.abandonConn(fd, curReq)
+		curReq.socketfd = 0
+		return
 	}
 
 	if curReq.completed {
 		curReq.httpCode = curReq.responseReader.ResponseCode
 		if curReq.httpCode != 200 {
-			curReq.error = true
-			b.stats.errorsUnexpectedHttpCode++
+			b.fail(curReq, reasonUnexpectedStatus)
+			b.statsFor(curReq).errorsUnexpectedHttpCode++
 		}
+		b.countRetriedAsError(curReq)
 		if 0 <= curReq.httpCode && curReq.httpCode < 1000 {
-			b.stats.httpCodes[curReq.httpCode]++
+			b.statsFor(curReq).httpCodes[curReq.httpCode]++
 		}
 
-		curReq.responseTime = time.Since(b.benchmark.startTime) - curReq.when
+		curReq.completedAt = now
+		curReq.responseTime = now - curReq.when
 
 		delete(b.reqsInProgress, fd)
+		b.releaseSlot(curReq)
 
-		b.stats.recordValue(curReq.responseTime)
+		b.statsFor(curReq).recordValue(curReq.responseTime)
 
 		err = unix.EpollCtl(b.epollfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{Events: unix.EPOLLRDHUP, Fd: int32(fd)})
 		if err != nil {
@@ -424,10 +700,14 @@ func (b *benchmarkWorker) handleConnectionReadyToRead(fd int, curReq *request) (
 			return
 		}
 
-		if b.benchmark.payload.keepAlive {
-			b.connRb.put(fd)
+		if c := b.conns[fd]; c != nil {
+			c.reqs++
+		}
+
+		if b.shouldRetireConn(fd) {
+			b.closeConn(fd, curReq)
 		} else {
-			unix.Close(fd)
+			b.connRb.put(fd)
 		}
 	}
 
@@ -442,22 +722,91 @@ func (b *benchmarkWorker) handleConnectionClosed(fd int, curReq *request) (err e
 		return
 	}
 
-	unix.Close(fd)
+	b.closeConn(fd, curReq)
 
 	if curReq == nil {
 		b.connRb.remove(fd)
 	} else {
-		// It's OK for an HTTP server to close the socket at any time. So we will reissue the request if this happened.
+		// It's OK for an HTTP server to close the socket at any time. So we will reissue the request if this happened,
+		// within the limits of the retry policy.
 		// Source: https://www.oreilly.com/library/view/http-the-definitive/1565925092/ch04s07.html,
 		delete(b.reqsInProgress, fd)
-		b.reissueRequest(curReq)
+		err = b.retryOrFail(curReq, curReq.closedReason())
+	}
+
+	return
+}
+
+// Fails the request on a connection that reported a socket error, and closes the connection.
+func (b *benchmarkWorker) handleSocketError(fd int, curReq *request, sockErr error) (err error) {
+	err = b.abandonConn(fd, curReq)
+	if err != nil {
+		return
+	}
+
+	if curReq == nil {
+		b.connRb.remove(fd)
+		return
+	}
+	if curReq.error {
+		return // Already accounted for.
+	}
+
+	// A reset of an established connection is the server closing it, which the retry policy may allow retrying.
+	reason := errnoReason(sockErr, curReq.connectedAt != 0)
+	if reason == reasonConnReset && curReq.connectedAt != 0 {
+		err = b.retryOrFail(curReq, reason)
+		return
 	}
 
+	b.releaseSlot(curReq)
+	b.fail(curReq, reason)
+	if curReq.connectedAt == 0 {
+		b.statsFor(curReq).errorsSocketConnect++
+	} else if !curReq.writtenDone {
+		b.statsFor(curReq).errorsSocketWrite++
+	} else {
+		b.statsFor(curReq).errorsNoResponse++
+	}
+	curReq.completedAt = time.Since(b.benchmark.startTime)
+	curReq.responseTime = curReq.completedAt - curReq.when
+	curReq.socketfd = 0
+	curReq.responseReader = ResponseReader{}
+
 	return
 }
 
+// Stops watching a connection and closes it, detaching it from the request it was serving.
+func (b *benchmarkWorker) abandonConn(fd int, r *request) (err error) {
+	err = unix.EpollCtl(b.epollfd, unix.EPOLL_CTL_DEL, fd, nil)
+	if err != nil {
+		err = fmt.Errorf("epoll_ctl failed on EPOLL_CTL_DEL for client socket fd %d: %v", fd, error(err))
+		return
+	}
+
+	b.closeConn(fd, r)
+	delete(b.reqsInProgress, fd)
+	return
+}
+
+// Marks a request as failed and counts the reason. Callers also count the error in one of the broad error counters.
+func (b *benchmarkWorker) fail(r *request, reason errorReason) {
+	r.error = true
+	r.errorReason = reason
+	b.statsFor(r).errorReasons[reason]++
+}
+
 func (b *benchmarkWorker) issueRequest(curReq *request) (err error) {
-	socketfd, ok := b.connRb.get()
+	curReq.attempts++
+	if curReq.sentAt == 0 {
+		curReq.sentAt = time.Since(b.benchmark.startTime)
+	}
+
+	socketfd, ok := b.getIdleConn(curReq)
+	if ok {
+		// A reused connection is already established.
+		curReq.connectedAt = time.Since(b.benchmark.startTime)
+	}
 
 	// If there was not an existing connection that could be reused we will create one.
 	if !ok {
@@ -467,25 +816,40 @@ func (b *benchmarkWorker) issueRequest(curReq *request) (err error) {
 			if err.Error() == "too many open files" {
 				panic("benchmark tool is being hindered by OS limit on number of open files.")
 			}
-			curReq.error = true
-			b.stats.errorsSocketCreate++
+			b.releaseSlot(curReq)
+			b.fail(curReq, reasonSocketCreate)
+			b.statsFor(curReq).errorsSocketCreate++
 			err = nil // Not a fatal error for the benchmark as a whole
 			return
 		}
 
-		// Connect client socket.
-		unix.Connect(socketfd, &b.benchmark.addr)
-		if err != nil {
-			curReq.error = true
-			b.stats.errorsSocketConnect++
+		b.connOpened(socketfd, curReq)
+
+		// Connect client socket. A non-blocking connect finishes in the background, and a failure to connect is then
+		// reported by epoll.
+		err = unix.Connect(socketfd, &b.benchmark.addr)
+		if err != nil && err != unix.EINPROGRESS {
+			b.releaseSlot(curReq)
+			b.fail(curReq, errnoReason(err, false))
+			b.statsFor(curReq).errorsSocketConnect++
+			b.closeConn(socketfd, curReq)
 			err = nil // Not a fatal error for the benchmark as a whole
 			return
 		}
 
-		unix.SetsockoptInt(socketfd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 1)
+		if b.benchmark.connectTimeout > 0 {
+			err = b.addDeadline(curReq, timeoutConnect, time.Since(b.benchmark.startTime)+b.benchmark.connectTimeout)
+			if err != nil {
+				return
+			}
+		}
+
+		err = unix.SetsockoptInt(socketfd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 1)
 		if err != nil {
-			curReq.error = true
-			b.stats.errorsSocketSetSockOpt++
+			b.releaseSlot(curReq)
+			b.fail(curReq, reasonSetSockOpt)
+			b.statsFor(curReq).errorsSocketSetSockOpt++
+			b.closeConn(socketfd, curReq)
 			err = nil // Not a fatal error for the benchmark as a whole
 			return
 		}
@@ -499,8 +863,8 @@ func (b *benchmarkWorker) issueRequest(curReq *request) (err error) {
 
 	curReq.socketfd = socketfd
 
-	b.writeRequest(curReq, socketfd)
-	if err != nil {
+	err = b.writeRequest(curReq, socketfd)
+	if err != nil || curReq.error {
 		return
 	}
 
@@ -516,16 +880,40 @@ func (b *benchmarkWorker) writeRequest(curReq *request, socketfd int) (err error
 		if err == unix.EAGAIN {
 			err = nil // Not a fatal error for the benchmark as a whole
 		} else {
-			curReq.error = true
-			b.stats.errorsSocketWrite++
+			b.releaseSlot(curReq)
+			b.fail(curReq, errnoReason(err, curReq.connectedAt != 0))
+			if curReq.connectedAt == 0 {
+				// The first write is where a connect that failed in the background is noticed.
+				b.statsFor(curReq).errorsSocketConnect++
+			} else {
+				b.statsFor(curReq).errorsSocketWrite++
+			}
+			curReq.completedAt = time.Since(b.benchmark.startTime)
+			curReq.responseTime = curReq.completedAt - curReq.when
+
+			// The connection is of no further use.
+			err = b.abandonConn(socketfd, curReq)
+			curReq.socketfd = 0
 			return
 		}
 	} else {
+		// A successful write means that the connection has been established, in case it was still connecting.
+		if curReq.connectedAt == 0 {
+			curReq.connectedAt = time.Since(b.benchmark.startTime)
+		}
+
 		curReq.writtenBytes += n
 		if curReq.writtenBytes == len(b.benchmark.payload.bytes) {
 			curReq.writtenDone = true
+			curReq.writeDoneAt = time.Since(b.benchmark.startTime)
+			if b.benchmark.firstByteTimeout > 0 {
+				err = b.addDeadline(curReq, timeoutFirstByte, curReq.writeDoneAt+b.benchmark.firstByteTimeout)
+				if err != nil {
+					return
+				}
+			}
 		}
-		b.stats.reqsWritten++
+		b.statsFor(curReq).reqsWritten++
 	}
 
 	// Add the socket to epoll.
@@ -553,12 +941,17 @@ func (b *benchmarkWorker) reissueRequest(curReq *request) (err error) {
 		return
 	}
 
-	// Since it's ok for a connection to be closed remotely, we don't consider it an error. Instead, reset and reissue the request.
+	// Reset and reissue the request.
 	curReq.writtenBytes = 0
 	if curReq.writtenDone {
-		b.stats.reqsWritten--
+		b.statsFor(curReq).reqsWritten--
 	}
 	curReq.writtenDone = false
+	curReq.connectedAt = 0
+	curReq.sentAt = 0 // Set again when the retry is sent, so that its phases leave out the failed attempt.
+	curReq.writeDoneAt = 0
+	curReq.firstByteAt = 0
+	curReq.responseReader = ResponseReader{}
 
 	err = b.issueRequest(curReq)
 	return
@@ -571,16 +964,33 @@ func (b *benchmarkWorker) closeAllFds() {
 			continue
 		}
 
-		r.error = true
-		b.stats.errorsNoResponse++ // TODO this should not be possible anymore now that timeouts are implemented.
-		unix.Close(fd)
+		b.releaseSlot(r)
+		if !b.benchmark.isAborted() {
+			b.fail(r, reasonNoResponse)
+			b.statsFor(r).errorsNoResponse++ // TODO this should not be possible anymore now that timeouts are implemented.
+		}
+		b.closeConn(fd, r)
 	}
 	b.reqsInProgress = make(map[int]*request)
 
+	for _, r := range b.reqsQueued {
+		if !r.queued {
+			continue
+		}
+
+		r.queued = false
+		atomic.AddInt64(&b.benchmark.reqsQueued, -1)
+		if !b.benchmark.isAborted() {
+			b.fail(r, reasonNoResponse)
+			b.statsFor(r).errorsNoResponse++
+		}
+	}
+	b.reqsQueued = nil
+
 	// Close all fd's in ringbuffer.
 	for {
 		if fd, ok := b.connRb.get(); ok {
-			unix.Close(fd)
+			b.closeConn(fd, nil)
 		} else {
 			break
 		}
@@ -637,155 +1047,317 @@ func (b *Benchmark) elapsed() time.Duration {
 	return et.Sub(b.startTime)
 }
 
-func (b *Benchmark) printStatus() {
-	var max time.Duration
-	var reqsConcurrent int
-	var connsAlive int
-	var reqsStarted uint
-	var reqsWritten uint
-	var respRecvd uint
-	var errors uint
+// Time elapsed in the measured part of the benchmark, i.e. after the warmup.
+func (b *Benchmark) measuredElapsed() time.Duration {
+	d := b.elapsed() - time.Duration(b.warmupSeconds)*time.Second
+	if d < 0 {
+		d = 0
+	}
+
+	return d
+}
+
+func (b *Benchmark) inWarmup() bool {
+	return b.elapsed() < time.Duration(b.warmupSeconds)*time.Second
+}
+
+// Copies the counters of the worker to its snapshot.
+func (b *benchmarkWorker) publishSnapshot() {
+	b.snapshot.mu.Lock()
+	defer b.snapshot.mu.Unlock()
+	b.snapshot.stats.copyFrom(b.stats)
+	b.snapshot.warmupStats.copyFrom(b.warmupStats)
+	b.snapshot.connsAlive = len(b.reqsInProgress) + b.connRb.size
+	b.snapshot.at = time.Now()
+}
 
+// Sums up the snapshots of all workers, with the stats of the measured part of the benchmark, the warmup part, or
+// both. Once the workers are done, their snapshots hold their final counts.
+func (b *Benchmark) mergeSnapshots(measured bool, warmup bool) (s *stats, connsAlive int) {
+	s = newStats()
+	s.hist = newHistogram(histogramLowestMicros, histogramHighestMicros, b.histSigFigs)
 	for _, w := range b.workers {
-		if w.stats.max > max {
-			max = w.stats.max
-		}
-
-		reqsConcurrent += len(w.reqsInProgress)
-		connsAlive += len(w.reqsInProgress) + w.connRb.size
-		reqsStarted += w.stats.reqsStarted
-		reqsWritten += w.stats.reqsWritten
-		respRecvd += w.stats.respRecvd
-		errors += w.stats.errorsTooManyConcurrent +
-			w.stats.errorsResponseReader +
-			w.stats.errorsNoResponse +
-			w.stats.errorsTimeout +
-			w.stats.errorsSocketCreate +
-			w.stats.errorsSocketSetSockOpt +
-			w.stats.errorsSocketConnect +
-			w.stats.errorsSocketWrite +
-			w.stats.errorsUnexpectedHttpCode
+		w.snapshot.mu.Lock()
+		if measured {
+			s.add(w.snapshot.stats)
+		}
+		if warmup {
+			s.add(w.snapshot.warmupStats)
+		}
+		connsAlive += w.snapshot.connsAlive
+		w.snapshot.mu.Unlock()
+	}
+	return
+}
+
+// Sums up the stats of all workers for the measured part of the benchmark.
+func (b *Benchmark) totalStats() (s *stats) {
+	s, _ = b.mergeSnapshots(true, false)
+	return
+}
+
+// Sums up the stats of all workers for the warmup part of the benchmark.
+func (b *Benchmark) totalWarmupStats() (s *stats) {
+	s, _ = b.mergeSnapshots(false, true)
+	return
+}
+
+// Sums up the stats of all workers for the whole benchmark, warmup included.
+func (b *Benchmark) allStats() (s *stats) {
+	s, _ = b.mergeSnapshots(true, true)
+	return
+}
+
+// Shows the progress of the benchmark on the dashboard if there is one, and as a status line if verbose.
+func (b *Benchmark) showStatus() {
+	if b.dashboard != nil {
+		b.dashboard.render(b)
+	} else if b.verbose {
+		b.printStatus()
 	}
+}
+
+func (b *Benchmark) printStatus() {
+	s, connsAlive := b.mergeSnapshots(true, true)
+
+	reqsConcurrent := atomic.LoadInt64(&b.reqsInFlight)
+	reqsQueued := atomic.LoadInt64(&b.reqsQueued)
 
 	elapsed := b.elapsed()
 
-	startedRate := float64(reqsStarted) / float64(float64(elapsed)/float64(time.Second))
-	writtenRate := float64(reqsWritten) / float64(float64(elapsed)/float64(time.Second))
+	startedRate := float64(s.reqsStarted) / float64(float64(elapsed)/float64(time.Second))
+	writtenRate := float64(s.reqsWritten) / float64(float64(elapsed)/float64(time.Second))
+	connsOpenedRate := float64(s.connsOpened) / float64(float64(elapsed)/float64(time.Second))
+	connsClosedRate := float64(s.connsClosed) / float64(float64(elapsed)/float64(time.Second))
 
-	maxResponseTimeMs := float64(max) / float64(time.Millisecond)
+	maxResponseTimeMs := float64(s.max) / float64(time.Millisecond)
+
+	if b.inWarmup() {
+		fmt.Printf("warmup ")
+	}
 
-	line := "alive: %4d, concurrent: %4d, startedRate: %9.2f , writtenRate: %9.2f ,  started: %6d , recvd:  %6d , errors: %6d, maxMs: %9.2f\n"
+	line := "alive: %4d, opened/s: %7.1f, closed/s: %7.1f, concurrent: %4d, queued: %4d, startedRate: %9.2f , writtenRate: %9.2f ,  started: %6d , recvd:  %6d , errors: %6d, maxMs: %9.2f\n"
 	fmt.Printf(line,
 		connsAlive,
+		connsOpenedRate,
+		connsClosedRate,
 		reqsConcurrent,
+		reqsQueued,
 		startedRate,
 		writtenRate,
-		reqsStarted,
-		respRecvd,
-		errors,
+		s.reqsStarted,
+		s.respRecvd,
+		s.errors(),
 		maxResponseTimeMs)
 }
 
-func (b *Benchmark) reqsConcurrent() (r int) {
-	for _, w := range b.workers {
-		r += len(w.reqsInProgress)
-	}
-	return
-}
+// Writes the latency histogram files that were asked for in the config.
+func (b *Benchmark) writeHistogramFiles() (err error) {
+	if b.hdrFile != "" {
+		var f *os.File
+		f, err = os.Create(b.hdrFile)
+		if err != nil {
+			return
+		}
+		defer f.Close()
 
-func (b *Benchmark) printSummary(r BenchmarkResult) {
-	var errorsTooManyConcurrent uint
-	var errorsResponseReader uint
-	var errorsNoResponse uint
-	var errorsTimeout uint
-	var errorsSocketCreate uint
-	var errorsSocketConnect uint
-	var errorsSocketSetSockOpt uint
-	var errorsSocketWrite uint
-	var errorsUnexpectedHttpCode uint
-	var httpCodes [1000]uint
+		err = b.totalStats().hist.writePercentileDistribution(f, 1000)
+		if err != nil {
+			return
+		}
 
-	for _, w := range b.workers {
-		errorsTooManyConcurrent += w.stats.errorsTooManyConcurrent
-		errorsResponseReader += w.stats.errorsResponseReader
-		errorsNoResponse += w.stats.errorsNoResponse
-		errorsTimeout += w.stats.errorsTimeout
-		errorsSocketCreate += w.stats.errorsSocketCreate
-		errorsSocketConnect += w.stats.errorsSocketConnect
-		errorsSocketSetSockOpt += w.stats.errorsSocketSetSockOpt
-		errorsSocketWrite += w.stats.errorsSocketWrite
-		errorsUnexpectedHttpCode += w.stats.errorsUnexpectedHttpCode
-		for i := 0; i < len(w.stats.httpCodes); i++ {
-			if w.stats.httpCodes[i] == 0 {
+		err = f.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	if b.hdrLogFile != "" {
+		// Group the latencies of the measured requests by the second in which they finished.
+		warmupEnd := time.Duration(b.warmupSeconds) * time.Second
+		intervals := make([]*histogram, 0, b.seconds)
+		for i := range b.ep.reqs {
+			r := &b.ep.reqs[i]
+			if r.warmup || r.responseTime == 0 {
 				continue
 			}
 
-			httpCodes[i] += w.stats.httpCodes[i]
+			interval := 0
+			if r.completedAt > warmupEnd {
+				interval = int((r.completedAt - warmupEnd) / time.Second)
+			}
+			for len(intervals) <= interval {
+				intervals = append(intervals, newHistogram(histogramLowestMicros, histogramHighestMicros, b.histSigFigs))
+			}
+			intervals[interval].record(int64(r.responseTime / time.Microsecond))
+		}
+
+		err = writeHistogramIntervalLog(b.hdrLogFile, b.startTime.Add(warmupEnd), time.Second, intervals, 1000)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// The load on the client host over the measured part of the benchmark, from the samples of the time series, with the
+// GC pauses of hlg over the whole benchmark.
+func (b *Benchmark) hostLoad() *hostLoad {
+	l := &hostLoad{}
+	n := 0
+	for i := range b.intervals {
+		if b.intervals[i].Warmup || b.intervals[i].Host == nil {
+			continue
 		}
+		l.add(b.intervals[i].Host, b.intervals[i].IntervalSeconds)
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	if b.startGC != nil && b.endGC != nil {
+		l.GCs, l.GCPauseMs, l.GCMaxPauseMs = b.endGC.since(b.startGC)
+	}
+
+	l.finish()
+	return l
+}
+
+// Whether the benchmark was stopped early because the SLO was certain to fail.
+func (b *Benchmark) isAborted() bool {
+	return atomic.LoadInt32(&b.aborted) == 1
+}
+
+// Number of requests either in flight or waiting for a concurrency slot.
+func (b *Benchmark) reqsConcurrent() (r int) {
+	return int(atomic.LoadInt64(&b.reqsInFlight) + atomic.LoadInt64(&b.reqsQueued))
+}
+
+func (b *Benchmark) printSummary(r BenchmarkResult) {
+	s := b.totalStats()
+
+	if b.warmupSeconds > 0 {
+		ws := b.totalWarmupStats()
+		fmt.Printf("warmupRecvd               %8d\n", ws.respRecvd)
+		fmt.Printf("warmupErrors              %8d\n", ws.errors())
 	}
 
 	fmt.Printf("startedRate rps           %11.2f\n", r.startedRate)
 	fmt.Printf("recvd                     %8d\n", r.recvd)
-	fmt.Printf("p99d9 ms                  %11.2f\n", float64(r.p99d9)/float64(time.Millisecond))
-	fmt.Printf("p99d99 ms                 %11.2f\n", float64(r.p99d99)/float64(time.Millisecond))
-	fmt.Printf("p99d999 ms                %11.2f\n", float64(r.p99d999)/float64(time.Millisecond))
+	for _, p := range r.percentiles {
+		if p >= 100 {
+			continue // Printed as max below.
+		}
+		fmt.Printf("%-26s%11.2f  (%d samples above)\n", percentileName(p)+" ms", float64(r.latencyAtPercentile(p))/float64(time.Millisecond), r.tailSamples(p))
+	}
 	fmt.Printf("max ms                    %11.2f\n", float64(r.max)/float64(time.Millisecond))
-	fmt.Printf("errorsTooManyConcurrent   %8d\n", errorsTooManyConcurrent)
-	fmt.Printf("errorsTooManyConcurrent   %8d\n", errorsTooManyConcurrent)
-	fmt.Printf("errorsResponseReader      %8d\n", errorsResponseReader)
-	fmt.Printf("errorsNoResponse          %8d\n", errorsNoResponse)
-	fmt.Printf("errorsTimeout             %8d\n", errorsTimeout)
-	fmt.Printf("errorsSocketCreate        %8d\n", errorsSocketCreate)
-	fmt.Printf("errorsSocketConnect       %8d\n", errorsSocketConnect)
-	fmt.Printf("errorsSocketSetSockOpt    %8d\n", errorsSocketSetSockOpt)
-	fmt.Printf("errorsSocketWrite         %8d\n", errorsSocketWrite)
-	fmt.Printf("errorsUnexpectedHttpCode  %8d\n", errorsUnexpectedHttpCode)
-	for i := 0; i < len(httpCodes); i++ {
-		if httpCodes[i] == 0 {
+	fmt.Printf("phase ms                  p50         p99       p99d9         max\n")
+	for p := phase(0); p < phaseCount; p++ {
+		fmt.Printf("  %-16s%11.2f %11.2f %11.2f %11.2f\n", phaseNames[p],
+			float64(r.phases[p].p50)/float64(time.Millisecond),
+			float64(r.phases[p].p99)/float64(time.Millisecond),
+			float64(r.phases[p].p99d9)/float64(time.Millisecond),
+			float64(r.phases[p].max)/float64(time.Millisecond))
+	}
+	fmt.Printf("  %-16s%11.2f %11.2f %11.2f %11.2f\n", "sendLag",
+		float64(r.sendLag.p50)/float64(time.Millisecond),
+		float64(r.sendLag.p99)/float64(time.Millisecond),
+		float64(r.sendLag.p99d9)/float64(time.Millisecond),
+		float64(r.sendLag.max)/float64(time.Millisecond))
+	fmt.Printf("connsOpened rps           %11.2f\n", r.connsOpenedRate)
+	fmt.Printf("connsClosed rps           %11.2f\n", r.connsClosedRate)
+	fmt.Printf("retries                   %8d\n", s.retries)
+	for _, c := range s.errorBreakdown() {
+		fmt.Printf("%-26s%8d\n", c.name, c.count)
+	}
+	if s.errors() > 0 {
+		fmt.Printf("error reasons\n")
+	}
+	for reason := errorReason(1); reason < reasonCount; reason++ {
+		if s.errorReasons[reason] == 0 {
 			continue
 		}
 
-		fmt.Printf("completedWithCode%03d      %8d\n", i, httpCodes[i])
+		fmt.Printf("  %-24s%8d\n", reason, s.errorReasons[reason])
 	}
-}
-
-func (b *Benchmark) calculateResult() (r BenchmarkResult) {
-	latencies := make([]time.Duration, 0, len(b.ep.reqs))
-	for _, r := range b.ep.reqs {
-		if r.responseTime == 0 {
+	for i := 0; i < len(s.httpCodes); i++ {
+		if s.httpCodes[i] == 0 {
 			continue
 		}
 
-		latencies = append(latencies, r.responseTime)
+		fmt.Printf("completedWithCode%03d      %8d\n", i, s.httpCodes[i])
 	}
-	if len(latencies) > 0 {
-		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
-		r.p99d9 = latencies[int(float64(len(latencies)-1)*0.999)]
-		r.p99d99 = latencies[int(float64(len(latencies)-1)*0.9999)]
-		r.p99d999 = latencies[int(float64(len(latencies)-1)*0.99999)]
+
+	if h := r.host; h != nil {
+		fmt.Printf("client cpu %%                %9.1f  (busiest core %.1f)\n", h.CPUBusyPct, maxFloat(h.CoreBusyPct))
+		fmt.Printf("client softirq %%            %9.1f  (busiest core %.1f)\n", meanFloat(h.CoreSoftirqPct), maxFloat(h.CoreSoftirqPct))
+		fmt.Printf("client ctxSwitches/s      %11.2f\n", h.CtxSwitchesPerSec)
+		fmt.Printf("client tcpRetransSegs     %8d  (%.2f%%)\n", h.TCPRetransSegs, h.TCPRetransPct)
+		fmt.Printf("client listenDrops        %8d\n", h.ListenDrops)
+		fmt.Printf("client max tcpInUse       %8d  (max %d in TIME_WAIT)\n", h.MaxTCPInUse, h.MaxTCPTimeWait)
+		fmt.Printf("client gcPause ms         %11.2f  (%d GCs, longest %.2f)\n", h.GCPauseMs, h.GCs, h.GCMaxPauseMs)
+		for _, w := range h.Warnings {
+			fmt.Printf("WARNING: %s, so the client host may have limited the benchmark.\n", w)
+		}
 	}
 
-	var reqsStarted uint
-	for _, w := range b.workers {
-		if w.stats.max > r.max {
-			r.max = w.stats.max
+	if r.saturated {
+		fmt.Printf("WARNING: hlg could not send requests on time, so the latencies include its own delays and the result is invalid. Use more CPUs or a lower rps.\n")
+	}
+
+	if r.sloPass {
+		fmt.Printf("slo                       pass\n")
+	} else if r.aborted {
+		fmt.Printf("slo                       fail, aborted early\n")
+		for _, v := range r.sloViolations {
+			fmt.Printf("  violated %s\n", v)
+		}
+	} else {
+		fmt.Printf("slo                       fail\n")
+		for _, v := range r.sloViolations {
+			fmt.Printf("  violated %s\n", v)
 		}
+	}
+}
 
-		reqsStarted += w.stats.reqsStarted
-		r.recvd += w.stats.respRecvd
-		r.errors += w.stats.errorsTooManyConcurrent +
-			w.stats.errorsResponseReader +
-			w.stats.errorsNoResponse +
-			w.stats.errorsTimeout +
-			w.stats.errorsSocketCreate +
-			w.stats.errorsSocketSetSockOpt +
-			w.stats.errorsSocketConnect +
-			w.stats.errorsSocketWrite +
-			w.stats.errorsUnexpectedHttpCode
+func (b *Benchmark) calculateResult() (r BenchmarkResult) {
+	s := b.totalStats()
+
+	r.stats = s
+	r.hist = s.hist
+	r.percentiles = b.percentiles
+
+	r.phases = calculatePhases(b.ep.reqs, b.histSigFigs)
+
+	r.max = s.max
+	r.recvd = s.respRecvd
+	r.started = s.reqsStarted
+	r.errors = s.errors()
+
+	elapsed := b.measuredElapsed()
+	r.seconds = elapsed.Seconds()
+	r.startedRate = float64(s.reqsStarted) / float64(float64(elapsed)/float64(time.Second))
+	r.connsOpenedRate = float64(s.connsOpened) / float64(float64(elapsed)/float64(time.Second))
+	r.connsClosedRate = float64(s.connsClosed) / float64(float64(elapsed)/float64(time.Second))
+	r.recvdRate = float64(s.respRecvd) / float64(float64(elapsed)/float64(time.Second))
+
+	r.sloPass, r.sloViolations = b.slo.evaluate(&r)
+	if b.isAborted() {
+		// The percentiles of the part that ran may still look fine, but the full benchmark could not have passed.
+		r.aborted = true
+		r.sloPass = false
+		r.sloViolations = b.abortViolations
 	}
 
-	elapsed := b.elapsed()
-	r.startedRate = float64(reqsStarted) / float64(float64(elapsed)/float64(time.Second))
+	r.host = b.hostLoad()
+
+	r.sendLag = newSendLagResult(s.sendLag)
+	if b.maxSendLag > 0 && r.sendLag.p99 > b.maxSendLag {
+		r.saturated = true
+		r.sloPass = false
+		r.sloViolations = append(r.sloViolations, fmt.Sprintf("p99 send lag<=%gms (was %g)", durationMs(b.maxSendLag), durationMs(r.sendLag.p99)))
+	}
 
 	return
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A benchmark with only what the concurrency slots need.
+func newSlotTestWorker(maxConcurrent int, mode concurrencyMode) *benchmarkWorker {
+	b := &Benchmark{}
+	b.maxConcurrent = maxConcurrent
+	b.concurrencyMode = mode
+	return &benchmarkWorker{benchmark: b, stats: newStats(), warmupStats: newStats()}
+}
+
+func TestAcquireSlotFailsAtMax(t *testing.T) {
+	// Arrange
+	w := newSlotTestWorker(2, concurrencyModeFail)
+	reqs := make([]request, 3)
+
+	// Act
+	first := w.benchmark.acquireSlot(&reqs[0])
+	second := w.benchmark.acquireSlot(&reqs[1])
+	third := w.benchmark.acquireSlot(&reqs[2])
+
+	// Assert
+	if !first || !second || third {
+		t.Fatalf("Unexpected acquired: %v %v %v", first, second, third)
+	}
+	if reqs[2].holdsSlot {
+		t.Fatalf("Unexpected holdsSlot for the request that did not get a slot")
+	}
+	if w.benchmark.reqsInFlight != 2 {
+		t.Fatalf("Unexpected reqsInFlight: %d", w.benchmark.reqsInFlight)
+	}
+}
+
+func TestReleaseSlotOnce(t *testing.T) {
+	// Arrange
+	w := newSlotTestWorker(1, concurrencyModeFail)
+	r := &request{}
+	w.benchmark.acquireSlot(r)
+
+	// Act
+	w.releaseSlot(r)
+	w.releaseSlot(r)
+
+	// Assert
+	if w.benchmark.reqsInFlight != 0 {
+		t.Fatalf("Unexpected reqsInFlight: %d", w.benchmark.reqsInFlight)
+	}
+	if r.holdsSlot {
+		t.Fatalf("Unexpected holdsSlot after releasing")
+	}
+	if !w.benchmark.acquireSlot(&request{}) {
+		t.Fatalf("Unexpected slot not available after releasing")
+	}
+}
+
+func TestReleaseSlotWithoutSlot(t *testing.T) {
+	// Arrange
+	w := newSlotTestWorker(1, concurrencyModeFail)
+	w.benchmark.acquireSlot(&request{})
+
+	// Act
+	w.releaseSlot(&request{})
+
+	// Assert
+	if w.benchmark.reqsInFlight != 1 {
+		t.Fatalf("Unexpected reqsInFlight: %d", w.benchmark.reqsInFlight)
+	}
+}
+
+func TestIssueQueuedRequestsWaitsForSlot(t *testing.T) {
+	// Arrange
+	w := newSlotTestWorker(1, concurrencyModeQueue)
+	w.benchmark.acquireSlot(&request{})
+	timedOut := &request{}
+	waiting := &request{queued: true}
+	w.reqsQueued = []*request{timedOut, waiting}
+	w.benchmark.reqsQueued = 1
+
+	// Act
+	err := w.issueQueuedRequests()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if len(w.reqsQueued) != 1 || w.reqsQueued[0] != waiting {
+		t.Fatalf("Unexpected reqsQueued: %v", w.reqsQueued)
+	}
+	if !waiting.queued || waiting.holdsSlot {
+		t.Fatalf("Unexpected queued request issued without a free slot")
+	}
+	if w.benchmark.reqsQueued != 1 || w.benchmark.reqsInFlight != 1 {
+		t.Fatalf("Unexpected reqsQueued %d or reqsInFlight %d", w.benchmark.reqsQueued, w.benchmark.reqsInFlight)
+	}
+}
+
+func TestMergeSnapshotsReadsOnlyPublishedCounts(t *testing.T) {
+	// Arrange
+	w := newSlotTestWorker(0, concurrencyModeFail)
+	w.benchmark.histSigFigs = 3
+	w.benchmark.workers = []*benchmarkWorker{w}
+	w.connRb = &ringbuffer{}
+	w.stats.hist = newHistogram(histogramLowestMicros, histogramHighestMicros, 3)
+	w.warmupStats.hist = newHistogram(histogramLowestMicros, histogramHighestMicros, 3)
+	w.snapshot.stats = newStats()
+	w.snapshot.warmupStats = newStats()
+	w.reqsInProgress = map[int]*request{5: {}}
+	w.stats.recordValue(2 * time.Millisecond)
+	w.warmupStats.recordValue(time.Millisecond)
+
+	// Act
+	w.publishSnapshot()
+	w.stats.recordValue(time.Second)
+	measured := w.benchmark.totalStats()
+	all, connsAlive := w.benchmark.mergeSnapshots(true, true)
+
+	// Assert
+	if measured.respRecvd != 1 || measured.hist.totalCount != 1 || measured.max != 2*time.Millisecond {
+		t.Fatalf("Unexpected measured stats: respRecvd %d, count %d, max %v", measured.respRecvd, measured.hist.totalCount, measured.max)
+	}
+	if all.respRecvd != 2 || all.hist.totalCount != 2 {
+		t.Fatalf("Unexpected stats: respRecvd %d, count %d", all.respRecvd, all.hist.totalCount)
+	}
+	if connsAlive != 1 {
+		t.Fatalf("Unexpected connsAlive: %d", connsAlive)
+	}
+}
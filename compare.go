@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Exit code of the compare command when a regression threshold was crossed. Invalid input exits with 1.
+const exitCodeRegression = 4
+
+// Loads the measured latencies of a benchmark from a latencies.bin or latencies.csv file, a histogram in the
+// HdrHistogram percentile distribution format as written by -hdrfile, or a JSON result as written by -output json or
+// to -resultsfile. Of a file with several results, the one with the given rps is used, or the last one if rps is 0.
+func loadDistribution(filename string, rps int) (d *distribution, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	data = bytes.TrimSpace(data)
+	switch {
+	case isRequestsFile(data):
+		d, err = parseRequestsDistribution(data, rps)
+	case bytes.HasPrefix(data, []byte("{")):
+		d, err = parseJSONDistribution(data, rps)
+	case bytes.HasPrefix(data, []byte("whenNs")):
+		d, err = parseLatenciesCSV(data)
+	case bytes.HasPrefix(data, []byte("Value")):
+		d, err = parsePercentileDistribution(data)
+	default:
+		err = fmt.Errorf("not a latencies.bin or latencies.csv file, histogram or JSON result")
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %v", filename, err)
+	}
+	return
+}
+
+// Reads the latencies of the measured requests of a test from a requests file. Failed requests with a latency are
+// included, as in parseLatenciesCSV.
+func parseRequestsDistribution(data []byte, rps int) (d *distribution, err error) {
+	recs, err := readRequestsTest(bytes.NewReader(data), rps)
+	if err != nil {
+		return
+	}
+
+	var samples []float64
+	for _, rec := range recs {
+		if !rec.warmup && rec.responseTime != 0 {
+			samples = append(samples, durationMs(rec.responseTime))
+		}
+	}
+	d = newDistribution(samples)
+	return
+}
+
+// Parses the latencies of the measured requests from a latencies.csv file. Failed requests with a latency, such as
+// timeouts, are included, as they are in the histograms.
+func parseLatenciesCSV(data []byte) (d *distribution, err error) {
+	lines := strings.Split(string(data), "\n")
+	columns := make(map[string]int)
+	for i, name := range strings.Split(strings.TrimSpace(lines[0]), ",") {
+		columns[name] = i
+	}
+	latencyCol, ok := columns["latencyMs"]
+	if !ok {
+		err = fmt.Errorf("no latencyMs column")
+		return
+	}
+	warmupCol, hasWarmup := columns["warmup"]
+
+	var samples []float64
+	for i, line := range lines[1:] {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) <= latencyCol || strings.TrimSpace(fields[latencyCol]) == "" {
+			continue
+		}
+		if hasWarmup && len(fields) > warmupCol && fields[warmupCol] == "1" {
+			continue
+		}
+
+		var v float64
+		v, err = strconv.ParseFloat(strings.TrimSpace(fields[latencyCol]), 64)
+		if err != nil {
+			err = fmt.Errorf("line %d: %v", i+2, err)
+			return
+		}
+		samples = append(samples, v)
+	}
+
+	d = newDistribution(samples)
+	return
+}
+
+// Parses a histogram in the HdrHistogram percentile distribution format. The samples between two lines are taken to
+// be at the value of the later line, so the distribution is only as fine as the percentile steps.
+func parsePercentileDistribution(data []byte) (d *distribution, err error) {
+	d = &distribution{}
+	var prevTotal int64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for first := true; scanner.Scan(); first = false {
+		fields := strings.Fields(scanner.Text())
+		if first || len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			err = fmt.Errorf("invalid line %q", scanner.Text())
+			return
+		}
+
+		var v float64
+		var total int64
+		v, err = strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return
+		}
+		total, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return
+		}
+
+		d.add(v, total-prevTotal)
+		prevTotal = total
+	}
+
+	err = scanner.Err()
+	return
+}
+
+// Parses the percentile spectrum of a JSON result, or of one of the JSON lines of a results file.
+func parseJSONDistribution(data []byte, rps int) (d *distribution, err error) {
+	var found *jsonRecord
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var rec jsonRecord
+		err = dec.Decode(&rec)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+
+		if rps == 0 || rec.Result.Rps == rps {
+			found = &rec
+		}
+	}
+	if found == nil {
+		err = fmt.Errorf("no result with rps %d", rps)
+		return
+	}
+
+	d = &distribution{}
+	var prevTotal int64
+	for _, p := range found.Result.Spectrum {
+		d.add(p.Ms, p.TotalCount-prevTotal)
+		prevTotal = p.TotalCount
+	}
+	return
+}
+
+// The outcome of comparing the result of a candidate build with a base result.
+type comparison struct {
+	base          *distribution
+	candidate     *distribution
+	percentiles   []float64
+	mannWhitney   testResult
+	probNewSlower float64 // Probability that a latency of the candidate exceeds one of the base result.
+	ks            testResult
+	regressions   []string // The percentiles that got slower by more than the threshold, if the difference is significant.
+}
+
+// Compares two latency distributions. A percentile regressed if it grew by more than maxIncrease, as a fraction of
+// the base value, and the Mann-Whitney or Kolmogorov-Smirnov test finds the distributions different at level alpha.
+func compareDistributions(base *distribution, candidate *distribution, percentiles []float64, maxIncrease float64, alpha float64) (c comparison) {
+	c = comparison{base: base, candidate: candidate, percentiles: percentiles}
+	c.mannWhitney, c.probNewSlower = mannWhitney(base, candidate)
+	c.ks = kolmogorovSmirnov(base, candidate)
+
+	if math.Min(c.mannWhitney.p, c.ks.p) >= alpha {
+		return
+	}
+
+	for _, p := range percentiles {
+		b, n := base.valueAtPercentile(p), candidate.valueAtPercentile(p)
+		if n > b*(1+maxIncrease) {
+			c.regressions = append(c.regressions, fmt.Sprintf("%s %.2fms -> %.2fms (%+.1f%%)", percentileName(p), b, n, relativeChange(b, n)*100))
+		}
+	}
+	return
+}
+
+// The change from a to b as a fraction of a.
+func relativeChange(a float64, b float64) float64 {
+	if a == 0 {
+		if b == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (b - a) / a
+}
+
+func (c comparison) print(w io.Writer, baseName string, candidateName string) {
+	fmt.Fprintf(w, "base  %s  (%d samples)\n", baseName, c.base.total)
+	fmt.Fprintf(w, "new   %s  (%d samples)\n\n", candidateName, c.candidate.total)
+
+	fmt.Fprintf(w, "%-12s%12s%12s%12s%12s\n", "percentile", "base ms", "new ms", "delta ms", "delta")
+	for _, p := range c.percentiles {
+		b, n := c.base.valueAtPercentile(p), c.candidate.valueAtPercentile(p)
+		fmt.Fprintf(w, "%-12s%12.2f%12.2f%+12.2f%+11.1f%%\n", percentileName(p), b, n, n-b, relativeChange(b, n)*100)
+	}
+
+	fmt.Fprintf(w, "\nmann-whitney        U %.4g, p %.4g, P(new > base) %.3f\n", c.mannWhitney.statistic, c.mannWhitney.p, c.probNewSlower)
+	fmt.Fprintf(w, "kolmogorov-smirnov  D %.4f, p %.4g\n\n", c.ks.statistic, c.ks.p)
+
+	if len(c.regressions) == 0 {
+		fmt.Fprintf(w, "no regression\n")
+		return
+	}
+	for _, r := range c.regressions {
+		fmt.Fprintf(w, "regression %s\n", r)
+	}
+}
+
+// Runs the compare subcommand with the arguments following it, and returns the exit code.
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: hlg compare [flags] BASE NEW\n\n")
+		fmt.Fprintf(fs.Output(), "Compares the latencies of two benchmarks, each given as a latencies.bin or latencies.csv file, a histogram written by -hdrfile, or a JSON result written by -output json or to -resultsfile. Exits with %d if NEW regressed.\n\n", exitCodeRegression)
+		fs.PrintDefaults()
+	}
+	percentilesArg := fs.String("percentiles", "50,90,99,99.9,99.99", "Comma separated latency percentiles to compare.")
+	maxIncreaseArg := fs.Float64("maxincrease", 0.1, "A percentile regressed if it grew by more than this fraction of its base value, and the difference is significant.")
+	alphaArg := fs.Float64("alpha", 0.01, "The difference between the latencies is significant if the p-value of the Mann-Whitney or Kolmogorov-Smirnov test is below this.")
+	rpsArg := fs.Int("rps", 0, "Of a results file with several results, compare the one with this rps. 0 means the last one.")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	percentiles, err := parsePercentiles(*percentilesArg, nil)
+	if err != nil || len(percentiles) == 0 {
+		fmt.Fprintf(os.Stderr, "Invalid percentiles: %v\n", *percentilesArg)
+		return 1
+	}
+
+	if *maxIncreaseArg < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid maxincrease: %v\n", *maxIncreaseArg)
+		return 1
+	}
+
+	if *alphaArg <= 0 || *alphaArg >= 1 {
+		fmt.Fprintf(os.Stderr, "Invalid alpha: %v\n", *alphaArg)
+		return 1
+	}
+
+	base, err := loadDistribution(fs.Arg(0), *rpsArg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	candidate, err := loadDistribution(fs.Arg(1), *rpsArg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	c := compareDistributions(base, candidate, percentiles, *maxIncreaseArg, *alphaArg)
+	c.print(os.Stdout, fs.Arg(0), fs.Arg(1))
+	if len(c.regressions) > 0 {
+		return exitCodeRegression
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseLatenciesCSV(t *testing.T) {
+	// Arrange
+	data := []byte("whenNs,written,completed,error,httpCode,latencyMs,warmup\n" +
+		"0,1,1,0,200,1.500000,1\n" +
+		"1000,1,1,0,200,2.500000,0\n" +
+		"2000,0,0,1,0,,0\n" +
+		"3000,1,1,0,200,3.500000,0\n")
+
+	// Act
+	d, err := parseLatenciesCSV(data)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.total != 2 || d.values[0] != 2.5 || d.values[1] != 3.5 {
+		t.Fatalf("Unexpected distribution: %+v", d)
+	}
+}
+
+func TestParsePercentileDistribution(t *testing.T) {
+	// Arrange
+	data := []byte(`       Value     Percentile TotalCount 1/(1-Percentile)
+
+       1.000 0.000000000000          2           1.00
+       2.000 0.500000000000          5           2.00
+       4.000 1.000000000000         10
+#[Mean    =        2.000, StdDeviation   =        1.000]
+#[Max     =        4.000, Total count    =           10]
+`)
+
+	// Act
+	d, err := parsePercentileDistribution(data)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.total != 10 || d.counts[0] != 2 || d.counts[1] != 3 || d.counts[2] != 5 {
+		t.Fatalf("Unexpected distribution: %+v", d)
+	}
+}
+
+func TestParseJSONDistribution(t *testing.T) {
+	// Arrange
+	data := []byte(`{"result":{"rps":100,"spectrum":[{"ms":1,"totalCount":4},{"ms":2,"totalCount":10}]}}
+{"result":{"rps":200,"spectrum":[{"ms":5,"totalCount":3}]}}
+`)
+
+	// Act
+	first, err1 := parseJSONDistribution(data, 100)
+	last, err2 := parseJSONDistribution(data, 0)
+	_, err3 := parseJSONDistribution(data, 300)
+
+	// Assert
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Unexpected errors: %v, %v", err1, err2)
+	}
+	if first.total != 10 || first.counts[1] != 6 {
+		t.Fatalf("Unexpected distribution: %+v", first)
+	}
+	if last.total != 3 || last.values[0] != 5 {
+		t.Fatalf("Unexpected distribution: %+v", last)
+	}
+	if err3 == nil {
+		t.Fatalf("Unexpected success for a missing rps")
+	}
+}
+
+func TestCompareDistributions(t *testing.T) {
+	// Arrange
+	var xs, slower, same []float64
+	for i := 0; i < 1000; i++ {
+		xs = append(xs, float64(i%100)+1)
+		slower = append(slower, (float64(i%100)+1)*1.5)
+		same = append(same, float64(i%100)+1)
+	}
+	base := newDistribution(xs)
+
+	// Act
+	regressed := compareDistributions(base, newDistribution(slower), []float64{50, 99}, 0.1, 0.01)
+	unchanged := compareDistributions(base, newDistribution(same), []float64{50, 99}, 0.1, 0.01)
+	tolerated := compareDistributions(base, newDistribution(slower), []float64{50, 99}, 0.6, 0.01)
+
+	// Assert
+	if len(regressed.regressions) != 2 {
+		t.Fatalf("Unexpected regressions: %v", regressed.regressions)
+	}
+	if len(unchanged.regressions) != 0 {
+		t.Fatalf("Unexpected regressions: %v", unchanged.regressions)
+	}
+	if len(tolerated.regressions) != 0 {
+		t.Fatalf("Unexpected regressions: %v", tolerated.regressions)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// How connections are closed when hlg itself decides to close them.
+type connCloseMode int
+
+const (
+	connCloseFin connCloseMode = iota // Graceful close, sending FIN.
+	connCloseRst                      // Abortive close, sending RST by setting SO_LINGER to 0 before closing.
+)
+
+// Bookkeeping for an open client connection.
+type connState struct {
+	opened time.Duration // Time since the beginning of the benchmark when the connection was opened.
+	reqs   int           // Number of requests completed on this connection.
+}
+
+// Registers a newly opened connection.
+func (b *benchmarkWorker) connOpened(fd int, r *request) {
+	b.conns[fd] = &connState{opened: time.Since(b.benchmark.startTime)}
+	b.statsFor(r).connsOpened++
+}
+
+// Gets an idle keep-alive connection that can be reused, closing any that have exceeded their max age while idle.
+func (b *benchmarkWorker) getIdleConn(r *request) (fd int, ok bool) {
+	for {
+		fd, ok = b.connRb.get()
+		if !ok {
+			return
+		}
+
+		if b.connExpired(fd) {
+			b.closeConn(fd, r)
+			continue
+		}
+
+		return
+	}
+}
+
+func (b *benchmarkWorker) connExpired(fd int) bool {
+	c := b.conns[fd]
+	if c == nil || b.benchmark.connMaxAge == 0 {
+		return false
+	}
+
+	return time.Since(b.benchmark.startTime)-c.opened >= b.benchmark.connMaxAge
+}
+
+// Decides whether a connection that just completed a request should be closed rather than kept for reuse.
+func (b *benchmarkWorker) shouldRetireConn(fd int) bool {
+	if !b.benchmark.payload.keepAlive {
+		return true
+	}
+
+	c := b.conns[fd]
+	if c == nil {
+		return false
+	}
+
+	if b.benchmark.connMaxRequests > 0 && c.reqs >= b.benchmark.connMaxRequests {
+		return true
+	}
+
+	if b.connExpired(fd) {
+		return true
+	}
+
+	if b.benchmark.connChurn > 0 && b.rand.Float64() < b.benchmark.connChurn {
+		return true
+	}
+
+	return false
+}
+
+// Closes a connection using the configured close mode, and counts it in the stats that r belongs to.
+func (b *benchmarkWorker) closeConn(fd int, r *request) {
+	if b.benchmark.connCloseMode == connCloseRst {
+		unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &unix.Linger{Onoff: 1, Linger: 0})
+	}
+
+	unix.Close(fd)
+
+	if _, ok := b.conns[fd]; ok {
+		delete(b.conns, fd)
+		b.statsFor(r).connsClosed++
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// A worker whose benchmark started now, with keep-alive requests and the given connection policies.
+func newConnTestWorker(maxRequests int, maxAge time.Duration, churn float64) *benchmarkWorker {
+	b := &Benchmark{payload: &reqPayload{keepAlive: true}, startTime: time.Now()}
+	b.connMaxRequests = maxRequests
+	b.connMaxAge = maxAge
+	b.connChurn = churn
+	return &benchmarkWorker{
+		benchmark:   b,
+		conns:       make(map[int]*connState),
+		connRb:      &ringbuffer{},
+		rand:        rand.New(rand.NewSource(1)),
+		stats:       newStats(),
+		warmupStats: newStats(),
+	}
+}
+
+// Opens a pipe to stand in for a connection, so that closing it closes a real file descriptor.
+func openTestConn(t *testing.T, w *benchmarkWorker, opened time.Duration, reqs int) int {
+	var fds [2]int
+	err := unix.Pipe(fds[:])
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	unix.Close(fds[1])
+	w.conns[fds[0]] = &connState{opened: opened, reqs: reqs}
+	return fds[0]
+}
+
+func TestShouldRetireConnByRequests(t *testing.T) {
+	// Arrange
+	w := newConnTestWorker(3, 0, 0)
+	w.conns[10] = &connState{reqs: 2}
+	w.conns[11] = &connState{reqs: 3}
+
+	// Act & Assert
+	if w.shouldRetireConn(10) {
+		t.Fatalf("Unexpected retire below connMaxRequests")
+	}
+	if !w.shouldRetireConn(11) {
+		t.Fatalf("Unexpected keep at connMaxRequests")
+	}
+}
+
+func TestShouldRetireConnByAge(t *testing.T) {
+	// Arrange
+	w := newConnTestWorker(0, time.Second, 0)
+	w.benchmark.startTime = time.Now().Add(-5 * time.Second)
+	w.conns[10] = &connState{opened: 4500 * time.Millisecond}
+	w.conns[11] = &connState{opened: 3 * time.Second}
+
+	// Act & Assert
+	if w.shouldRetireConn(10) {
+		t.Fatalf("Unexpected retire of a young connection")
+	}
+	if !w.shouldRetireConn(11) {
+		t.Fatalf("Unexpected keep of an old connection")
+	}
+}
+
+func TestShouldRetireConnByChurn(t *testing.T) {
+	// Arrange
+	w := newConnTestWorker(0, 0, 0.25)
+	w.conns[10] = &connState{}
+
+	// Act
+	retired := 0
+	for i := 0; i < 10000; i++ {
+		if w.shouldRetireConn(10) {
+			retired++
+		}
+	}
+
+	// Assert
+	if retired < 2200 || retired > 2800 {
+		t.Fatalf("Unexpected retired: %d", retired)
+	}
+}
+
+func TestShouldRetireConnWithoutKeepAlive(t *testing.T) {
+	// Arrange
+	w := newConnTestWorker(0, 0, 0)
+	w.benchmark.payload.keepAlive = false
+	w.conns[10] = &connState{}
+
+	// Act & Assert
+	if !w.shouldRetireConn(10) {
+		t.Fatalf("Unexpected keep without keep-alive")
+	}
+}
+
+func TestShouldRetireConnNoPolicy(t *testing.T) {
+	// Arrange
+	w := newConnTestWorker(0, 0, 0)
+	w.conns[10] = &connState{reqs: 1000}
+
+	// Act & Assert
+	if w.shouldRetireConn(10) {
+		t.Fatalf("Unexpected retire without any policy")
+	}
+}
+
+func TestGetIdleConnClosesExpired(t *testing.T) {
+	// Arrange
+	w := newConnTestWorker(0, time.Second, 0)
+	w.benchmark.startTime = time.Now().Add(-5 * time.Second)
+	expired := openTestConn(t, w, 0, 1)
+	fresh := openTestConn(t, w, 4500*time.Millisecond, 1)
+	w.connRb.put(expired)
+	w.connRb.put(fresh)
+	defer unix.Close(fresh)
+
+	// Act
+	fd, ok := w.getIdleConn(&request{})
+
+	// Assert
+	if !ok || fd != fresh {
+		t.Fatalf("Unexpected fd %d, ok %v", fd, ok)
+	}
+	if _, found := w.conns[expired]; found {
+		t.Fatalf("Unexpected expired connection still registered")
+	}
+	if w.stats.connsClosed != 1 {
+		t.Fatalf("Unexpected connsClosed: %d", w.stats.connsClosed)
+	}
+	if _, ok = w.getIdleConn(&request{}); ok {
+		t.Fatalf("Unexpected idle connection left")
+	}
+}
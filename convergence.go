@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+)
+
+// z-score of the two-sided 95% confidence interval of a percentile.
+const convergenceZ = 1.96
+
+// Tracks whether the latency percentiles of a running benchmark are known precisely enough, so that a benchmark
+// with an adaptive duration can stop.
+type convergence struct {
+	percentiles []float64 // The percentiles to watch, all below 100.
+	tolerance   float64   // Precise enough once the confidence interval of each percentile is narrower than this fraction of its upper end.
+}
+
+func newConvergence(percentiles []float64, tolerance float64) *convergence {
+	c := &convergence{tolerance: tolerance}
+	for _, p := range percentiles {
+		if p < 100 {
+			c.percentiles = append(c.percentiles, p)
+		}
+	}
+	return c
+}
+
+// Minimum number of samples needed to estimate a percentile, such that at least one sample lies above it.
+// For example p99.999 needs 100000 samples.
+func requiredSamples(p float64) int64 {
+	if p >= 100 {
+		return 1
+	}
+	return int64(math.Ceil(100/(100-p) - 1e-9))
+}
+
+// The 95% confidence interval of a percentile of the latencies in the histogram. It makes no assumption about the
+// distribution: of n samples, the number below the true percentile p is binomial with mean np, so the interval runs
+// between the samples at the ranks that many standard deviations below and above np. Not ok if there are too few
+// samples for one end of the interval to lie within them.
+func percentileConfidenceInterval(h *histogram, p float64) (lo int64, hi int64, ok bool) {
+	n := float64(h.totalCount)
+	q := p / 100
+	half := convergenceZ * math.Sqrt(n*q*(1-q))
+	loRank := math.Floor(n*q - half)
+	hiRank := math.Ceil(n*q + half)
+	if loRank < 1 || hiRank > n {
+		return
+	}
+
+	lo = h.valueAtPercentile(100 * loRank / n)
+	hi = h.valueAtPercentile(100 * hiRank / n)
+	ok = true
+	return
+}
+
+// Whether, going by the histogram of all measured latencies so far, there are enough samples for every percentile and
+// its confidence interval is within the tolerance.
+func (c *convergence) converged(h *histogram) bool {
+	for _, p := range c.percentiles {
+		if h.totalCount < requiredSamples(p) {
+			return false
+		}
+
+		lo, hi, ok := percentileConfidenceInterval(h, p)
+		if !ok || float64(hi-lo) > c.tolerance*float64(hi) {
+			return false
+		}
+	}
+
+	return true
+}
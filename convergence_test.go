@@ -0,0 +1,85 @@
+package main
+
+import "testing"
+
+func TestRequiredSamples(t *testing.T) {
+	if requiredSamples(99.999) != 100000 {
+		t.Fatalf("Unexpected samples: %d", requiredSamples(99.999))
+	}
+	if requiredSamples(99) != 100 {
+		t.Fatalf("Unexpected samples: %d", requiredSamples(99))
+	}
+	if requiredSamples(50) != 2 {
+		t.Fatalf("Unexpected samples: %d", requiredSamples(50))
+	}
+}
+
+func TestPercentileConfidenceInterval(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+	for v := int64(1); v <= 2000; v++ {
+		h.record(v)
+	}
+
+	// Act
+	lo, hi, ok := percentileConfidenceInterval(h, 50)
+
+	// Assert
+	if !ok || lo != 956 || hi != 1044 {
+		t.Fatalf("Unexpected interval: %d, %d, %v", lo, hi, ok)
+	}
+}
+
+func TestPercentileConfidenceIntervalTooFewSamples(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+	for v := int64(1); v <= 200; v++ {
+		h.record(v)
+	}
+
+	// Act
+	_, _, ok := percentileConfidenceInterval(h, 99)
+
+	// Assert
+	if ok {
+		t.Fatalf("Unexpected interval with 2 samples above p99")
+	}
+}
+
+func TestConvergenceNarrowsWithSamples(t *testing.T) {
+	// Arrange
+	c := newConvergence([]float64{50, 99, 100}, 0.02)
+	few := newHistogram(1, 3600000000, 3)
+	many := newHistogram(1, 3600000000, 3)
+	for v := int64(1); v <= 1000; v++ {
+		few.record(v)
+		many.recordCount(v, 100)
+	}
+
+	// Act
+	convergedFew := c.converged(few)
+	convergedMany := c.converged(many)
+
+	// Assert
+	if convergedFew {
+		t.Fatalf("Unexpected convergence with 1000 samples")
+	}
+	if !convergedMany {
+		t.Fatalf("Unexpected no convergence with 100000 samples")
+	}
+}
+
+func TestConvergenceNeedsRequiredSamples(t *testing.T) {
+	// Arrange
+	c := newConvergence([]float64{99.999}, 0.5)
+	h := newHistogram(1, 3600000000, 3)
+	h.recordCount(1000, 99999)
+
+	// Act
+	converged := c.converged(h)
+
+	// Assert
+	if converged {
+		t.Fatalf("Unexpected convergence")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Height in rows of the rolling latency chart.
+const dashboardChartHeight = 8
+
+// Number of search steps shown on the dashboard.
+const dashboardHistorySteps = 8
+
+// Characters for drawing bars in eighths of a row.
+var chartBlocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// A full-screen view of the running benchmark, redrawn every second in place of the plain status lines.
+type dashboard struct {
+	out       io.Writer
+	width     int
+	search    *search // Nil unless searching for a sustainable rps.
+	history   []hillClimbStep
+	startTime time.Time
+}
+
+// Returns nil if stdout is not a terminal, so that output redirected to a file or pipe keeps the plain lines.
+func newDashboard() *dashboard {
+	fd := int(os.Stdout.Fd())
+	if _, err := unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+		return nil
+	}
+
+	d := &dashboard{out: os.Stdout, width: 80, startTime: time.Now()}
+	if ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ); err == nil && ws.Col > 0 {
+		d.width = int(ws.Col)
+	}
+	return d
+}
+
+// Updates the search state and history shown on the dashboard.
+func (d *dashboard) searchUpdated(s *search, history []hillClimbStep) {
+	d.search = s
+	d.history = history
+}
+
+// Draws the benchmark as of its latest recorded interval, so that it does not read the state of the workers.
+func (d *dashboard) render(b *Benchmark) {
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H\x1b[2J") // Move to the top left and clear the screen.
+
+	phase := "running"
+	plannedRps := b.rps
+	if b.inWarmup() {
+		phase = "warmup"
+		if b.warmupRps > 0 {
+			plannedRps = b.warmupRps
+		}
+	} else if b.done {
+		phase = "waiting for last responses"
+	}
+	fmt.Fprintf(&buf, "hlg  target %s  rps %d  %s  %.0fs of %ds  (total %s)\n\n", b.host, b.rps, phase,
+		b.elapsed().Seconds(), b.warmupSeconds+b.seconds, time.Since(d.startTime).Truncate(time.Second))
+
+	var last timeSeriesSample
+	if len(b.intervals) > 0 {
+		last = b.intervals[len(b.intervals)-1]
+	}
+	rate := func(n uint) float64 {
+		if last.IntervalSeconds == 0 {
+			return 0
+		}
+		return float64(n) / last.IntervalSeconds
+	}
+	fmt.Fprintf(&buf, "send rate    planned %9d/s  actual %9.1f/s  written %9.1f/s  recvd %9.1f/s\n", plannedRps, rate(last.Started), rate(last.Written), rate(last.Recvd))
+	fmt.Fprintf(&buf, "concurrency  in flight %7d  queued %7d  conns open %7d  opened %7.1f/s  closed %7.1f/s\n\n",
+		last.Concurrent, last.Queued, last.ConnsAlive, rate(last.ConnsOpened), rate(last.ConnsClosed))
+
+	// Chart the highest reported percentile below the max, per second.
+	chartP := 50.0
+	for _, p := range b.percentiles {
+		if p < 100 {
+			chartP = p
+		}
+	}
+	name := percentileName(chartP)
+	var values []float64
+	for _, iv := range b.intervals {
+		values = append(values, iv.LatencyMs[name])
+	}
+	chartWidth := d.width - 12
+	if chartWidth < 10 {
+		chartWidth = 10
+	}
+	errors, maxMs := intervalTotals(b.intervals)
+	fmt.Fprintf(&buf, "%s ms per second, last %s %.2f, max %.2f\n", name, name, last.LatencyMs[name], maxMs)
+	lines, _ := renderChart(values, dashboardChartHeight, chartWidth)
+	for _, line := range lines {
+		fmt.Fprintf(&buf, "%s\n", line)
+	}
+	fmt.Fprintf(&buf, "\n")
+
+	fmt.Fprintf(&buf, "errors")
+	var kinds []string
+	for kind := range errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(&buf, "  %s %d", strings.TrimPrefix(kind, "errors"), errors[kind])
+	}
+	if len(kinds) == 0 {
+		fmt.Fprintf(&buf, "  none")
+	}
+	fmt.Fprintf(&buf, "\n")
+
+	// The intervals only have the status codes as the stats recorded along with the latest of them.
+	fmt.Fprintf(&buf, "codes ")
+	if s := b.prevIntervalStats; s != nil {
+		for code, n := range s.httpCodes {
+			if n > 0 {
+				fmt.Fprintf(&buf, "  %d: %d", code, n)
+			}
+		}
+	}
+	fmt.Fprintf(&buf, "\n")
+
+	if d.search != nil {
+		fmt.Fprintf(&buf, "\n%s search  %v\n", d.search.strategy, d.search)
+		start := len(d.history) - dashboardHistorySteps
+		if start < 0 {
+			start = 0
+		}
+		for _, step := range d.history[start:] {
+			verdict := "fail"
+			if step.Pass {
+				verdict = "pass"
+			}
+			fmt.Fprintf(&buf, "  rps %7d  %s  errors %6d", step.Rps, verdict, step.Errors)
+			for _, p := range b.percentiles {
+				fmt.Fprintf(&buf, "  %s %.2f", percentileName(p), step.PercentilesMs[percentileName(p)])
+			}
+			fmt.Fprintf(&buf, "\n")
+		}
+	}
+
+	d.out.Write(buf.Bytes())
+}
+
+// Sums up the errors by kind over the intervals, leaving out kinds without errors, and finds the highest latency.
+func intervalTotals(intervals []timeSeriesSample) (errors map[string]uint, maxMs float64) {
+	errors = make(map[string]uint)
+	for _, iv := range intervals {
+		for kind, n := range iv.Errors {
+			if n > 0 {
+				errors[kind] += n
+			}
+		}
+		maxMs = math.Max(maxMs, iv.LatencyMs["max"])
+	}
+	return
+}
+
+// Draws the latest values as a bar chart of the given height, with a y axis label on the top and bottom rows.
+// Each column is one value, and the scale is set by the highest value shown, which is returned as top.
+func renderChart(values []float64, height int, width int) (lines []string, top float64) {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+
+	for _, v := range values {
+		top = math.Max(top, v)
+	}
+
+	for row := height - 1; row >= 0; row-- {
+		label := ""
+		switch row {
+		case height - 1:
+			label = fmt.Sprintf("%.1f", top)
+		case 0:
+			label = "0"
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%9s |", label)
+		for _, v := range values {
+			eighths := 0
+			if top > 0 {
+				eighths = int(v/top*float64(height*8)+0.5) - row*8
+			}
+			if eighths < 0 {
+				eighths = 0
+			}
+			if eighths > 8 {
+				eighths = 8
+			}
+			sb.WriteRune(chartBlocks[eighths])
+		}
+		lines = append(lines, sb.String())
+	}
+
+	return
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestRenderChart(t *testing.T) {
+	// Arrange
+	values := []float64{1, 2, 4, 0}
+
+	// Act
+	lines, top := renderChart(values, 2, 10)
+
+	// Assert
+	if top != 4 {
+		t.Fatalf("Unexpected top: %v", top)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected lines: %q", lines)
+	}
+	if lines[0] != "      4.0 |  █ " {
+		t.Fatalf("Unexpected top row: %q", lines[0])
+	}
+	if lines[1] != "        0 |▄██ " {
+		t.Fatalf("Unexpected bottom row: %q", lines[1])
+	}
+}
+
+func TestRenderChartKeepsLatest(t *testing.T) {
+	// Arrange
+	values := []float64{8, 1, 2}
+
+	// Act
+	lines, top := renderChart(values, 1, 2)
+
+	// Assert
+	if top != 2 {
+		t.Fatalf("Unexpected top: %v", top)
+	}
+	if lines[0] != "      2.0 |▄█" {
+		t.Fatalf("Unexpected row: %q", lines[0])
+	}
+}
+
+func TestIntervalTotals(t *testing.T) {
+	// Arrange
+	intervals := []timeSeriesSample{
+		{Errors: map[string]uint{"errorsTimeout": 2, "errorsSocketConnect": 0}, LatencyMs: map[string]float64{"max": 12.5}},
+		{Errors: map[string]uint{"errorsTimeout": 1, "errorsNoResponse": 3}, LatencyMs: map[string]float64{"max": 4}},
+	}
+
+	// Act
+	errors, maxMs := intervalTotals(intervals)
+
+	// Assert
+	if len(errors) != 2 || errors["errorsTimeout"] != 3 || errors["errorsNoResponse"] != 3 {
+		t.Fatalf("Unexpected errors: %v", errors)
+	}
+	if maxMs != 12.5 {
+		t.Fatalf("Unexpected maxMs: %v", maxMs)
+	}
+}
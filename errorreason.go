@@ -0,0 +1,110 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// The specific reason a request failed, finer grained than the error counters of stats.
+type errorReason int
+
+const (
+	reasonNone                  errorReason = iota
+	reasonTooManyConcurrent                 // The request would have exceeded maxConcurrent.
+	reasonSocketCreate                      // Creating the socket failed.
+	reasonSetSockOpt                        // Setting socket options failed.
+	reasonConnRefused                       // ECONNREFUSED, nothing listens on the target port.
+	reasonConnReset                         // ECONNRESET or EPIPE, the target reset the connection.
+	reasonConnectTimedOut                   // ETIMEDOUT before the connection was established, the kernel gave up on the handshake.
+	reasonAddrNotAvail                      // EADDRNOTAVAIL, no local port was free for a new connection.
+	reasonSocketOther                       // Any other socket error.
+	reasonParseStatusLine                   // The status line of the response could not be parsed.
+	reasonParseHeader                       // A header of the response could not be parsed.
+	reasonParseChunk                        // A chunk length of a chunked response could not be parsed.
+	reasonTotalTimeoutQueued                // Ran past the total timeout waiting for a concurrency slot.
+	reasonTotalTimeoutConnect               // Ran past the total timeout before the connection was established.
+	reasonTotalTimeoutWrite                 // Ran past the total timeout before the full request was written.
+	reasonTotalTimeoutFirstByte             // Ran past the total timeout waiting for the first byte of the response.
+	reasonTotalTimeoutBody                  // Ran past the total timeout while receiving the response.
+	reasonConnectTimeout                    // Ran past the connect timeout.
+	reasonFirstByteTimeout                  // Ran past the time to first byte timeout.
+	reasonUnexpectedStatus                  // Got a response with a status code other than 200.
+	reasonNoResponse                        // Still in flight when the benchmark ended.
+	reasonClosedIdle                        // The server closed the connection before any of the response arrived, and the request was not retried.
+	reasonClosedMidResponse                 // The server closed the connection after part of the response arrived, and the request was not retried.
+	reasonCount
+)
+
+var errorReasonNames = [reasonCount]string{
+	"",
+	"tooManyConcurrent",
+	"socketCreate",
+	"setSockOpt",
+	"connRefused",
+	"connReset",
+	"connectTimedOut",
+	"addrNotAvail",
+	"socketOther",
+	"parseStatusLine",
+	"parseHeader",
+	"parseChunk",
+	"totalTimeoutQueued",
+	"totalTimeoutConnect",
+	"totalTimeoutWrite",
+	"totalTimeoutFirstByte",
+	"totalTimeoutBody",
+	"connectTimeout",
+	"firstByteTimeout",
+	"unexpectedStatus",
+	"noResponse",
+	"closedIdle",
+	"closedMidResponse",
+}
+
+func (e errorReason) String() string {
+	return errorReasonNames[e]
+}
+
+// Classifies a socket error by its errno. Connected tells whether the connection had been established, since
+// ETIMEDOUT on an established connection is not a connect timeout.
+func errnoReason(err error, connected bool) errorReason {
+	switch err {
+	case unix.ECONNREFUSED:
+		return reasonConnRefused
+	case unix.ECONNRESET, unix.EPIPE:
+		return reasonConnReset
+	case unix.ETIMEDOUT:
+		if !connected {
+			return reasonConnectTimedOut
+		}
+	case unix.EADDRNOTAVAIL:
+		return reasonAddrNotAvail
+	}
+
+	return reasonSocketOther
+}
+
+// Classifies a request that ran past the total timeout while in flight by how far it got.
+func (r *request) timeoutReason() errorReason {
+	switch {
+	case r.connectedAt == 0:
+		return reasonTotalTimeoutConnect
+	case r.writeDoneAt == 0:
+		return reasonTotalTimeoutWrite
+	case r.firstByteAt == 0:
+		return reasonTotalTimeoutFirstByte
+	default:
+		return reasonTotalTimeoutBody
+	}
+}
+
+// Gets and clears the pending error of a socket, or returns nil if there is none.
+func socketError(fd int) error {
+	n, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	return unix.Errno(n)
+}
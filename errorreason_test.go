@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestErrnoReason(t *testing.T) {
+	if errnoReason(unix.ECONNREFUSED, false) != reasonConnRefused {
+		t.Fatalf("Unexpected reason: %v", errnoReason(unix.ECONNREFUSED, false))
+	}
+	if errnoReason(unix.EPIPE, true) != reasonConnReset {
+		t.Fatalf("Unexpected reason: %v", errnoReason(unix.EPIPE, true))
+	}
+	if errnoReason(unix.ETIMEDOUT, false) != reasonConnectTimedOut {
+		t.Fatalf("Unexpected reason: %v", errnoReason(unix.ETIMEDOUT, false))
+	}
+	if errnoReason(unix.ETIMEDOUT, true) != reasonSocketOther {
+		t.Fatalf("Unexpected reason: %v", errnoReason(unix.ETIMEDOUT, true))
+	}
+	if errnoReason(unix.EADDRNOTAVAIL, false) != reasonAddrNotAvail {
+		t.Fatalf("Unexpected reason: %v", errnoReason(unix.EADDRNOTAVAIL, false))
+	}
+}
+
+func TestTimeoutReason(t *testing.T) {
+	// Arrange
+	r := request{sentAt: 1}
+
+	// Act & Assert
+	if r.timeoutReason() != reasonTotalTimeoutConnect {
+		t.Fatalf("Unexpected reason: %v", r.timeoutReason())
+	}
+	r.connectedAt = 2
+	if r.timeoutReason() != reasonTotalTimeoutWrite {
+		t.Fatalf("Unexpected reason: %v", r.timeoutReason())
+	}
+	r.writeDoneAt = 3
+	if r.timeoutReason() != reasonTotalTimeoutFirstByte {
+		t.Fatalf("Unexpected reason: %v", r.timeoutReason())
+	}
+	r.firstByteAt = 4
+	if r.timeoutReason() != reasonTotalTimeoutBody {
+		t.Fatalf("Unexpected reason: %v", r.timeoutReason())
+	}
+}
+
+func TestErrorReasonNames(t *testing.T) {
+	for reason := errorReason(1); reason < reasonCount; reason++ {
+		if reason.String() == "" {
+			t.Fatalf("Unexpected empty name for reason %d", int(reason))
+		}
+	}
+}
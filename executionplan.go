@@ -1,53 +1,70 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
-	"os"
 	"time"
 )
 
 type request struct {
 	when           time.Duration // How many microseconds since the beginning of the benchmark until this request shall be sent
 	responseTime   time.Duration // How long time elapsed since "when" until the response was received
+	sentAt         time.Duration // Time since the beginning of the benchmark when hlg actually started sending the latest attempt of this request.
+	connectedAt    time.Duration // Time since the beginning of the benchmark when the connection for this request was established.
+	writeDoneAt    time.Duration // Time since the beginning of the benchmark when the full request was written.
+	firstByteAt    time.Duration // Time since the beginning of the benchmark when the first byte of the response arrived.
+	completedAt    time.Duration // Time since the beginning of the benchmark when the response was received, or the request failed.
 	writtenBytes   int
 	writtenDone    bool
 	completed      bool
 	error          bool
+	errorReason    errorReason // Why the request failed, if it did.
+	attempts       int         // Number of times the request was issued, including retries.
 	httpCode       int
 	responseReader ResponseReader
 	workerID       int
 	socketfd       int
+	holdsSlot      bool // Whether this request currently occupies one of the benchmark's maxConcurrent slots.
+	queued         bool // Whether this request is waiting for a concurrency slot to become available.
+	warmup         bool // Whether this request is part of the warmup, and should therefore be excluded from the results.
+	started        bool // Whether the time for this request came before the benchmark ended. Benchmarks that stop early leave the rest unstarted.
 }
 
 type executionPlan struct {
-	reqs             []request
-	workerPos        []int      // Element i in this slice represents the position in reqs of the next request the i'th worker must send.
-	workerPosTimeout []int      // Element i in this slice represents the position in reqs of the next request the i'th worker should time out if it was not yet finished.
-	latestTimeoutReq []*request // Element i in this slice is the current req that the i'th worker should time out if it was not yet finished.
+	reqs      []request
+	workerPos []int // Element i in this slice represents the position in reqs of the next request the i'th worker must send.
 }
 
-func newExecutionPlan(rps int, seconds int, workerCount int) (e *executionPlan) {
+func newExecutionPlan(rps int, seconds int, warmupRps int, warmupSeconds int, workerCount int) (e *executionPlan) {
 	e = &executionPlan{}
 
+	// The warmup requests come first, followed by the measured requests starting right when the warmup ends.
+	warmupCount := warmupRps * warmupSeconds
+	e.reqs = make([]request, warmupCount+rps*seconds)
+	if warmupCount > 0 {
+		secondsPerWarmupRequest := time.Duration(float64(time.Second) / float64(warmupRps))
+		for i := 0; i < warmupCount; i++ {
+			e.reqs[i].when = time.Duration(i) * secondsPerWarmupRequest
+			e.reqs[i].warmup = true
+		}
+	}
+
 	secondsPerRequest := time.Duration(float64(time.Second) / float64(rps))
-	e.reqs = make([]request, rps*seconds)
-	for i := 1; i < len(e.reqs); i++ {
-		e.reqs[i].when = e.reqs[i-1].when + secondsPerRequest
+	warmupEnd := time.Duration(warmupSeconds) * time.Second
+	for i := warmupCount; i < len(e.reqs); i++ {
+		e.reqs[i].when = warmupEnd + time.Duration(i-warmupCount)*secondsPerRequest
+	}
+
+	for i := 0; i < len(e.reqs); i++ {
 		e.reqs[i].workerID = i % workerCount
 	}
 
 	// Initialize worker positions to point to the first request each worker should send.
 	e.workerPos = make([]int, workerCount, workerCount)
-	e.workerPosTimeout = make([]int, workerCount, workerCount)
-	e.latestTimeoutReq = make([]*request, workerCount, workerCount)
 	for workerID := 0; workerID < workerCount; workerID++ {
 		nextPos := 0
 		for nextPos < len(e.reqs) && e.reqs[nextPos].workerID != workerID {
 			nextPos++
 		}
 		e.workerPos[workerID] = nextPos
-		e.workerPosTimeout[workerID] = nextPos
 	}
 
 	return
@@ -71,33 +88,6 @@ func (e *executionPlan) getNext(workerID int) (r *request) {
 	return
 }
 
-// Gets the next request that could potentially time out.
-func (e *executionPlan) getNextPotentialTimeoutReq(workerID int) (r *request) {
-	e.latestTimeoutReq[workerID] = nil
-
-	if e.workerPosTimeout[workerID] == len(e.reqs) {
-		return
-	}
-
-	if e.workerPosTimeout[workerID] == e.workerPos[workerID] {
-		// There are no requests currently in flight that we haven't already monitored for time out.
-		return
-	}
-
-	r = &e.reqs[e.workerPosTimeout[workerID]]
-	e.latestTimeoutReq[workerID] = r
-
-	// Set worker timeout position to point to the next request this worker should time out if it was not yet finished.
-	nextPos := e.workerPosTimeout[workerID]
-	nextPos++
-	for nextPos < len(e.reqs) && e.reqs[nextPos].workerID != workerID && e.workerPosTimeout[workerID] < e.workerPos[workerID] && !e.reqs[nextPos].completed && !e.reqs[nextPos].error {
-		nextPos++
-	}
-	e.workerPosTimeout[workerID] = nextPos
-
-	return
-}
-
 func (e *executionPlan) peekNext(workerID int) (r *request) {
 	if e.done(workerID) {
 		return
@@ -110,54 +100,3 @@ func (e *executionPlan) peekNext(workerID int) (r *request) {
 func (e *executionPlan) done(workerID int) bool {
 	return e.workerPos[workerID] == len(e.reqs)
 }
-
-func (e *executionPlan) writeResultsFile(filename string) {
-	resultsFile, err := os.Create(filename)
-	defer resultsFile.Close()
-	if err != nil {
-		fmt.Printf("%v\n", err)
-		return
-	}
-	resultsFileWriter := bufio.NewWriter(resultsFile)
-	fmt.Fprintf(resultsFileWriter, "whenNs")
-	fmt.Fprintf(resultsFileWriter, ",written")
-	fmt.Fprintf(resultsFileWriter, ",completed")
-	fmt.Fprintf(resultsFileWriter, ",error")
-	fmt.Fprintf(resultsFileWriter, ",httpCode")
-	fmt.Fprintf(resultsFileWriter, ",latencyMs")
-	fmt.Fprintf(resultsFileWriter, "\n")
-	for _, r := range e.reqs {
-		fmt.Fprintf(resultsFileWriter, "%d", r.when)
-
-		w := 0
-		if r.writtenDone {
-			w = 1
-		}
-		fmt.Fprintf(resultsFileWriter, ",%d", w)
-
-		c := 0
-		if r.completed {
-			c = 1
-		}
-		fmt.Fprintf(resultsFileWriter, ",%d", c)
-
-		e := 0
-		if r.error {
-			e = 1
-		}
-		fmt.Fprintf(resultsFileWriter, ",%d", e)
-
-		fmt.Fprintf(resultsFileWriter, ",%d", r.httpCode)
-
-		if r.responseTime != 0 {
-			v := float64(r.responseTime) / float64(time.Millisecond)
-			fmt.Fprintf(resultsFileWriter, ",%7f", v)
-		} else {
-			fmt.Fprintf(resultsFileWriter, ",")
-		}
-
-		fmt.Fprintf(resultsFileWriter, "\n")
-	}
-	resultsFileWriter.Flush()
-	resultsFile.Close()
-}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExecutionPlanWarmupSplit(t *testing.T) {
+	// Arrange & Act
+	e := newExecutionPlan(100, 2, 10, 3, 4)
+
+	// Assert
+	if len(e.reqs) != 230 {
+		t.Fatalf("Unexpected len(reqs): %d", len(e.reqs))
+	}
+	for i, r := range e.reqs {
+		if r.warmup != (i < 30) {
+			t.Fatalf("Unexpected warmup %v for request %d", r.warmup, i)
+		}
+	}
+	if e.reqs[29].when != 2900*time.Millisecond {
+		t.Fatalf("Unexpected when of the last warmup request: %v", e.reqs[29].when)
+	}
+	if e.reqs[30].when != 3*time.Second {
+		t.Fatalf("Unexpected when of the first measured request: %v", e.reqs[30].when)
+	}
+	if e.reqs[229].when != 3*time.Second+1990*time.Millisecond {
+		t.Fatalf("Unexpected when of the last measured request: %v", e.reqs[229].when)
+	}
+}
+
+func TestExecutionPlanWithoutWarmup(t *testing.T) {
+	// Arrange & Act
+	e := newExecutionPlan(100, 2, 100, 0, 4)
+
+	// Assert
+	if len(e.reqs) != 200 {
+		t.Fatalf("Unexpected len(reqs): %d", len(e.reqs))
+	}
+	if e.reqs[0].warmup || e.reqs[0].when != 0 {
+		t.Fatalf("Unexpected first request: %+v", e.reqs[0])
+	}
+}
+
+func TestExecutionPlanWorkersSeeEveryRequestInOrder(t *testing.T) {
+	// Arrange
+	e := newExecutionPlan(10, 1, 5, 1, 3)
+
+	// Act
+	seen := 0
+	for workerID := 0; workerID < 3; workerID++ {
+		var prev time.Duration = -1
+		for r := e.getNext(workerID); r != nil; r = e.getNext(workerID) {
+			if r.workerID != workerID || r.when <= prev {
+				t.Fatalf("Unexpected request for worker %d: %+v", workerID, r)
+			}
+			prev = r.when
+			seen++
+		}
+	}
+
+	// Assert
+	if seen != len(e.reqs) {
+		t.Fatalf("Unexpected seen: %d", seen)
+	}
+}
+
+func TestStatsForWarmup(t *testing.T) {
+	// Arrange
+	w := &benchmarkWorker{stats: newStats(), warmupStats: newStats()}
+
+	// Act & Assert
+	if w.statsFor(&request{warmup: true}) != w.warmupStats {
+		t.Fatalf("Unexpected stats for a warmup request")
+	}
+	if w.statsFor(&request{}) != w.stats {
+		t.Fatalf("Unexpected stats for a measured request")
+	}
+	if w.statsFor(nil) != w.stats {
+		t.Fatalf("Unexpected stats for no request")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Cookies from the HdrHistogram V2 encoding. The 0x10 marks a word size of 8 bytes with zero run-length encoded counts.
+const (
+	hdrEncodingCookie           = 0x1c849303 | 0x10
+	hdrCompressedEncodingCookie = 0x1c849304 | 0x10
+)
+
+// A point of the percentile distribution of a histogram.
+type percentilePoint struct {
+	percentile float64 // From 0 to 100.
+	value      int64
+	totalCount int64 // Number of values at or below the value.
+}
+
+// Gets the percentile distribution of the histogram at the same percentile steps as HdrHistogram, except for the
+// final 100th percentile, which is left to the caller.
+func (h *histogram) percentileSpectrum() (points []percentilePoint) {
+	const ticksPerHalfDistance = 5
+
+	if h.totalCount == 0 {
+		return
+	}
+
+	percentileLevel := 0.0
+	var total int64
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+
+		total += c
+		v := h.highestEquivalentValue(h.valueFromCountsIndex(i))
+		for 100*float64(total)/float64(h.totalCount) >= percentileLevel {
+			points = append(points, percentilePoint{percentileLevel, v, total})
+
+			// Report at increasingly fine percentile steps, halving the step each time the distance to 100 halves.
+			halvings := math.Floor(math.Log2(100/(100-percentileLevel))) + 1
+			percentileLevel += 100 / (ticksPerHalfDistance * math.Pow(2, halvings))
+
+			if total == h.totalCount {
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// Writes the histogram in the HdrHistogram percentile distribution text format, as also produced by wrk2.
+// Values are divided by scale before being written, e.g. 1000 to write microsecond values as milliseconds.
+func (h *histogram) writePercentileDistribution(w io.Writer, scale float64) (err error) {
+	bw := bufio.NewWriter(w)
+	valueFormat := fmt.Sprintf("%%12.%df", h.significantFigures)
+
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+
+	if h.totalCount > 0 {
+		for _, p := range h.percentileSpectrum() {
+			fmt.Fprintf(bw, valueFormat+" %2.12f %10d %14.2f\n", float64(p.value)/scale, p.percentile/100, p.totalCount, 1/(1-p.percentile/100))
+		}
+
+		fmt.Fprintf(bw, valueFormat+" %2.12f %10d\n", float64(h.max())/scale, 1.0, h.totalCount)
+	}
+
+	fmt.Fprintf(bw, "#[Mean    = "+valueFormat+", StdDeviation   = "+valueFormat+"]\n", h.mean()/scale, h.stdDev()/scale)
+	fmt.Fprintf(bw, "#[Max     = "+valueFormat+", Total count    = %12d]\n", float64(h.max())/scale, h.totalCount)
+	fmt.Fprintf(bw, "#[Buckets = %12d, SubBuckets     = %12d]\n", h.bucketCount, h.subBucketCount)
+
+	err = bw.Flush()
+	return
+}
+
+// Encodes the histogram in the HdrHistogram V2 binary format.
+func (h *histogram) encode() []byte {
+	var payload bytes.Buffer
+	var varintBuf [9]byte
+
+	countsLimit := 0
+	if h.totalCount > 0 {
+		countsLimit = h.countsIndexFor(h.maxValue) + 1
+	}
+
+	for i := 0; i < countsLimit; {
+		c := h.counts[i]
+		i++
+
+		// Runs of more than one zero are written as a single negative number.
+		zeros := int64(0)
+		if c == 0 {
+			zeros = 1
+			for i < countsLimit && h.counts[i] == 0 {
+				zeros++
+				i++
+			}
+		}
+
+		if zeros > 1 {
+			c = -zeros
+		}
+
+		n := putZigZagVarint(varintBuf[:], c)
+		payload.Write(varintBuf[:n])
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int32(hdrEncodingCookie))
+	binary.Write(&buf, binary.BigEndian, int32(payload.Len()))
+	binary.Write(&buf, binary.BigEndian, int32(0)) // Normalizing index offset.
+	binary.Write(&buf, binary.BigEndian, int32(h.significantFigures))
+	binary.Write(&buf, binary.BigEndian, h.lowestDiscernibleValue)
+	binary.Write(&buf, binary.BigEndian, h.highestTrackableValue)
+	binary.Write(&buf, binary.BigEndian, float64(1)) // Integer to double value conversion ratio.
+	buf.Write(payload.Bytes())
+	return buf.Bytes()
+}
+
+// Encodes the histogram in the HdrHistogram V2 compressed binary format, as used in interval logs.
+func (h *histogram) encodeCompressed() (b []byte, err error) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, err = zw.Write(h.encode())
+	if err != nil {
+		return
+	}
+	err = zw.Close()
+	if err != nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, int32(hdrCompressedEncodingCookie))
+	binary.Write(&buf, binary.BigEndian, int32(compressed.Len()))
+	buf.Write(compressed.Bytes())
+	b = buf.Bytes()
+	return
+}
+
+// ZigZag LEB128 encoding as used by HdrHistogram. The 9th byte, if needed, holds a full 8 bits.
+func putZigZagVarint(buf []byte, v int64) (n int) {
+	u := uint64((v << 1) ^ (v >> 63))
+	for n < 8 {
+		if u < 0x80 {
+			buf[n] = byte(u)
+			n++
+			return
+		}
+		buf[n] = byte(u&0x7f) | 0x80
+		u >>= 7
+		n++
+	}
+	buf[n] = byte(u)
+	n++
+	return
+}
+
+// Writes a series of consecutive interval histograms in the HdrHistogram interval log format.
+// Interval max values are divided by scale before being written.
+func writeHistogramIntervalLog(filename string, startTime time.Time, intervalLength time.Duration, intervals []*histogram, scale float64) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	startSeconds := float64(startTime.UnixNano()) / float64(time.Second)
+	fmt.Fprintf(bw, "#[Histogram log format version 1.3]\n")
+	fmt.Fprintf(bw, "#[StartTime: %.3f (seconds since epoch), %s]\n", startSeconds, startTime.Format(time.UnixDate))
+	fmt.Fprintf(bw, "#[BaseTime: %.3f (seconds since epoch)]\n", startSeconds)
+	fmt.Fprintf(bw, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n")
+
+	for i, h := range intervals {
+		var b []byte
+		b, err = h.encodeCompressed()
+		if err != nil {
+			return
+		}
+
+		intervalStart := float64(time.Duration(i)*intervalLength) / float64(time.Second)
+		fmt.Fprintf(bw, "%.3f,%.3f,%.3f,%s\n", intervalStart, float64(intervalLength)/float64(time.Second), float64(h.max())/scale, base64.StdEncoding.EncodeToString(b))
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// One benchmark of the hill climb, as kept in the checkpoint.
+type hillClimbStep struct {
+	Rps           int                `json:"rps"`
+	Pass          bool               `json:"pass"`
+	Errors        uint               `json:"errors"`
+	Recvd         uint               `json:"recvd"`
+	PercentilesMs map[string]float64 `json:"percentilesMs"`
+}
+
+// Everything needed to continue a hill climb where it left off.
+type hillClimbCheckpoint struct {
+	Slo           string          `json:"slo"`
+	Percentiles   []float64       `json:"percentiles"`
+	Strategy      string          `json:"strategy"`
+	Tolerance     float64         `json:"tolerance"`
+	MaxIterations int             `json:"maxIterations"`
+	Repeats       int             `json:"repeats"`
+	Rps           int             `json:"rps"`
+	StepFactor    float64         `json:"stepFactor"`
+	Lower         int             `json:"lower"`
+	Upper         int             `json:"upper"`
+	Iterations    int             `json:"iterations"`
+	TrialRuns     int             `json:"trialRuns"`
+	TrialPasses   int             `json:"trialPasses"`
+	ClientLimit   int             `json:"clientLimit"`
+	History       []hillClimbStep `json:"history"`
+}
+
+func newHillClimbStep(rps int, r *BenchmarkResult) hillClimbStep {
+	step := hillClimbStep{
+		Rps:           rps,
+		Pass:          r.sloPass,
+		Errors:        r.errors,
+		Recvd:         r.recvd,
+		PercentilesMs: make(map[string]float64),
+	}
+	for _, p := range r.percentiles {
+		step.PercentilesMs[percentileName(p)] = float64(r.latencyAtPercentile(p)) / float64(time.Millisecond)
+	}
+	return step
+}
+
+// Writes the search state and history to the checkpoint file. The file is replaced atomically, so that a crash
+// while writing leaves the previous checkpoint intact.
+func saveHillClimbCheckpoint(filename string, s *search, o slo, percentiles []float64, history []hillClimbStep) (err error) {
+	c := hillClimbCheckpoint{
+		Slo:           o.String(),
+		Percentiles:   percentiles,
+		Strategy:      s.strategy.String(),
+		Tolerance:     s.tolerance,
+		MaxIterations: s.maxIterations,
+		Repeats:       s.repeats,
+		Rps:           s.rps,
+		StepFactor:    s.stepFactor,
+		Lower:         s.lower,
+		Upper:         s.upper,
+		Iterations:    s.iterations,
+		TrialRuns:     s.trialRuns,
+		TrialPasses:   s.trialPasses,
+		ClientLimit:   s.clientLimit,
+		History:       history,
+	}
+
+	// Avoid escaping the comparison operators of the SLO, to keep the file readable.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(c)
+	if err != nil {
+		return
+	}
+
+	tmp := filename + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(tmp, filename)
+	return
+}
+
+// Reads a checkpoint written by saveHillClimbCheckpoint and restores the search from it.
+func loadHillClimbCheckpoint(filename string) (s *search, sloText string, percentiles []float64, history []hillClimbStep, err error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	var c hillClimbCheckpoint
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		err = fmt.Errorf("invalid checkpoint %v: %v", filename, err)
+		return
+	}
+
+	strategy, ok := searchStrategyNames[c.Strategy]
+	if !ok {
+		err = fmt.Errorf("invalid checkpoint %v: unknown strategy %q", filename, c.Strategy)
+		return
+	}
+
+	s = newSearch(strategy, c.Rps, c.Tolerance, c.MaxIterations, c.Repeats)
+	s.stepFactor = c.StepFactor
+	s.lower = c.Lower
+	s.upper = c.Upper
+	s.iterations = c.Iterations
+	s.trialRuns = c.TrialRuns
+	s.trialPasses = c.TrialPasses
+	s.clientLimit = c.ClientLimit
+	sloText = c.Slo
+	percentiles = c.Percentiles
+	history = c.History
+	return
+}
+
+// Checks that a resumed search has the SLO and percentiles of the checkpoint, so that its steps are judged, and written
+// to hillclimb.csv, the same way as the ones before.
+func checkResumable(sloText string, percentiles []float64, cfg benchmarkConfig) error {
+	if sloText != cfg.slo.String() {
+		return fmt.Errorf("checkpoint was made with SLO %v, not %v; resume with the same -slo", sloText, cfg.slo)
+	}
+
+	same := len(percentiles) == len(cfg.percentiles)
+	for i := 0; same && i < len(percentiles); i++ {
+		same = percentiles[i] == cfg.percentiles[i]
+	}
+	if !same {
+		return fmt.Errorf("checkpoint was made with percentiles %v, not %v; resume with the same -percentiles", percentiles, cfg.percentiles)
+	}
+
+	return nil
+}
+
+// Writes the header of hillclimb.csv, with a column for each percentile and each kind of error.
+func writeHillClimbHeader(filename string, percentiles []float64) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "rps,pass,startedRate,recvd,errors")
+	for _, p := range percentiles {
+		if p >= 100 {
+			continue // Written as maxms.
+		}
+		fmt.Fprintf(w, ",%sms", percentileName(p))
+	}
+	fmt.Fprintf(w, ",maxms")
+	for _, c := range (&stats{}).errorBreakdown() {
+		fmt.Fprintf(w, ",%s", c.name)
+	}
+	fmt.Fprintf(w, "\n")
+
+	err = w.Flush()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
+
+// Appends the result of one benchmark to hillclimb.csv.
+func appendHillClimbRow(filename string, rps int, r *BenchmarkResult) (err error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	pass := 0
+	if r.sloPass {
+		pass = 1
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "%d,%d,%f,%d,%d", rps, pass, r.startedRate, r.recvd, r.errors)
+	for _, p := range r.percentiles {
+		if p >= 100 {
+			continue
+		}
+		fmt.Fprintf(w, ",%f", float64(r.latencyAtPercentile(p))/float64(time.Millisecond))
+	}
+	fmt.Fprintf(w, ",%f", float64(r.max)/float64(time.Millisecond))
+	for _, c := range r.stats.errorBreakdown() {
+		fmt.Fprintf(w, ",%d", c.count)
+	}
+	fmt.Fprintf(w, "\n")
+
+	err = w.Flush()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
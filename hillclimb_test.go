@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHillClimbCheckpointRoundTrip(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "hlg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "hillclimb.json")
+
+	o, err := parseSlo("p99<=100ms,errors<=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSearch(searchNoisy, 1000, 0.05, 10, 3)
+	s.record(true)
+	s.record(false)
+	s.record(true)
+	history := []hillClimbStep{{Rps: 1000, Pass: true, PercentilesMs: map[string]float64{"p99": 12.5}}}
+
+	// Act
+	err = saveHillClimbCheckpoint(filename, s, o, []float64{99, 99.9}, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, sloText, percentiles, history2, err := loadHillClimbCheckpoint(filename)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *s2 != *s {
+		t.Fatalf("Unexpected search state: %+v, expected %+v", *s2, *s)
+	}
+	if sloText != "p99<=100ms,errors<=0" {
+		t.Fatalf("Unexpected SLO: %v", sloText)
+	}
+	if len(percentiles) != 2 || percentiles[1] != 99.9 {
+		t.Fatalf("Unexpected percentiles: %v", percentiles)
+	}
+	if len(history2) != 1 || history2[0].Rps != 1000 || history2[0].PercentilesMs["p99"] != 12.5 {
+		t.Fatalf("Unexpected history: %+v", history2)
+	}
+}
+
+func TestCheckResumable(t *testing.T) {
+	// Arrange
+	o, err := parseSlo("p99<=100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := benchmarkConfig{slo: o, percentiles: []float64{99, 99.9}}
+
+	// Act & Assert
+	if err := checkResumable("p99<=100ms", []float64{99, 99.9}, cfg); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := checkResumable("p99<=200ms", []float64{99, 99.9}, cfg); err == nil {
+		t.Fatalf("Unexpected success with another SLO")
+	}
+	if err := checkResumable("p99<=100ms", []float64{99}, cfg); err == nil {
+		t.Fatalf("Unexpected success with other percentiles")
+	}
+	if err := checkResumable("p99<=100ms", nil, cfg); err == nil {
+		t.Fatalf("Unexpected success without percentiles")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// A high dynamic range histogram, following the design of Gil Tene's HdrHistogram.
+// Values are recorded with a fixed number of significant decimal digits of precision, using memory proportional
+// to the log of the value range rather than the number of recorded values.
+type histogram struct {
+	lowestDiscernibleValue      int64
+	highestTrackableValue       int64
+	significantFigures          int
+	unitMagnitude               uint
+	subBucketHalfCountMagnitude uint
+	subBucketCount              int
+	subBucketHalfCount          int
+	subBucketMask               int64
+	bucketCount                 int
+	counts                      []int64
+	totalCount                  int64
+	minValue                    int64
+	maxValue                    int64
+}
+
+// Units and range used for all latency histograms in hlg. Values are recorded in microseconds, up to an hour.
+const (
+	histogramLowestMicros  = 1
+	histogramHighestMicros = 60 * 60 * 1000 * 1000
+)
+
+func newHistogram(lowestDiscernibleValue int64, highestTrackableValue int64, significantFigures int) (h *histogram) {
+	h = &histogram{
+		lowestDiscernibleValue: lowestDiscernibleValue,
+		highestTrackableValue:  highestTrackableValue,
+		significantFigures:     significantFigures,
+		minValue:               math.MaxInt64,
+	}
+
+	largestValueWithSingleUnitResolution := 2 * int64(math.Pow10(significantFigures))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestValueWithSingleUnitResolution))))
+	if subBucketCountMagnitude > 0 {
+		h.subBucketHalfCountMagnitude = subBucketCountMagnitude - 1
+	}
+	h.unitMagnitude = uint(math.Floor(math.Log2(float64(lowestDiscernibleValue))))
+	h.subBucketCount = 1 << (h.subBucketHalfCountMagnitude + 1)
+	h.subBucketHalfCount = h.subBucketCount / 2
+	h.subBucketMask = int64(h.subBucketCount-1) << h.unitMagnitude
+
+	// Determine how many buckets of doubling size are needed to cover the highest trackable value.
+	smallestUntrackableValue := int64(h.subBucketCount) << h.unitMagnitude
+	h.bucketCount = 1
+	for smallestUntrackableValue <= highestTrackableValue {
+		if smallestUntrackableValue > math.MaxInt64/2 {
+			h.bucketCount++
+			break
+		}
+		smallestUntrackableValue <<= 1
+		h.bucketCount++
+	}
+
+	h.counts = make([]int64, (h.bucketCount+1)*h.subBucketHalfCount)
+	return
+}
+
+// Creates an empty histogram with the same configuration as h.
+func (h *histogram) newEmptyCopy() *histogram {
+	return newHistogram(h.lowestDiscernibleValue, h.highestTrackableValue, h.significantFigures)
+}
+
+// Records a value. Values outside the trackable range are clamped to it.
+func (h *histogram) record(v int64) {
+	h.recordCount(v, 1)
+}
+
+func (h *histogram) recordCount(v int64, n int64) {
+	if v < 0 {
+		v = 0
+	}
+	if v > h.highestTrackableValue {
+		v = h.highestTrackableValue
+	}
+
+	h.counts[h.countsIndexFor(v)] += n
+	h.totalCount += n
+	if v < h.minValue {
+		h.minValue = v
+	}
+	if v > h.maxValue {
+		h.maxValue = v
+	}
+}
+
+// Adds all values recorded in o to h. Both histograms must have the same configuration.
+func (h *histogram) merge(o *histogram) {
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.totalCount += o.totalCount
+	if o.minValue < h.minValue {
+		h.minValue = o.minValue
+	}
+	if o.maxValue > h.maxValue {
+		h.maxValue = o.maxValue
+	}
+}
+
+// Removes the values recorded in o from h, where o holds a subset of the values of h, such as an earlier snapshot
+// of it. The min and max of h are left as they are.
+func (h *histogram) subtract(o *histogram) {
+	for i, c := range o.counts {
+		h.counts[i] -= c
+	}
+	h.totalCount -= o.totalCount
+}
+
+func (h *histogram) reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.totalCount = 0
+	h.minValue = math.MaxInt64
+	h.maxValue = 0
+}
+
+// Gets the value at the given percentile (0 to 100). Returns the highest value that is equivalent to the recorded
+// value within the histogram's precision, so the result is never below the true percentile.
+func (h *histogram) valueAtPercentile(percentile float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+
+	if percentile > 100 {
+		percentile = 100
+	}
+
+	countAtPercentile := int64(percentile/100*float64(h.totalCount) + 0.5)
+	if countAtPercentile < 1 {
+		countAtPercentile = 1
+	}
+
+	var total int64
+	for i, c := range h.counts {
+		total += c
+		if total >= countAtPercentile {
+			return h.highestEquivalentValue(h.valueFromCountsIndex(i))
+		}
+	}
+
+	return 0
+}
+
+// Counts the recorded values that are certainly above v, i.e. those whose lowest equivalent value is above v.
+func (h *histogram) countAbove(v int64) (n int64) {
+	for i, c := range h.counts {
+		if c != 0 && h.lowestEquivalentValue(h.valueFromCountsIndex(i)) > v {
+			n += c
+		}
+	}
+	return
+}
+
+func (h *histogram) max() int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.highestEquivalentValue(h.maxValue)
+}
+
+func (h *histogram) min() int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.lowestEquivalentValue(h.minValue)
+}
+
+func (h *histogram) mean() float64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+
+	var sum float64
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		sum += float64(h.medianEquivalentValue(h.valueFromCountsIndex(i))) * float64(c)
+	}
+	return sum / float64(h.totalCount)
+}
+
+func (h *histogram) stdDev() float64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+
+	mean := h.mean()
+	var sum float64
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		d := float64(h.medianEquivalentValue(h.valueFromCountsIndex(i))) - mean
+		sum += d * d * float64(c)
+	}
+	return math.Sqrt(sum / float64(h.totalCount))
+}
+
+func (h *histogram) bucketIndex(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	return pow2Ceiling - int(h.unitMagnitude) - int(h.subBucketHalfCountMagnitude+1)
+}
+
+func (h *histogram) subBucketIndex(v int64, bucketIndex int) int {
+	return int(v >> (uint(bucketIndex) + h.unitMagnitude))
+}
+
+func (h *histogram) countsIndex(bucketIndex int, subBucketIndex int) int {
+	bucketBaseIndex := (bucketIndex + 1) << h.subBucketHalfCountMagnitude
+	return bucketBaseIndex + subBucketIndex - h.subBucketHalfCount
+}
+
+func (h *histogram) countsIndexFor(v int64) int {
+	bucketIndex := h.bucketIndex(v)
+	return h.countsIndex(bucketIndex, h.subBucketIndex(v, bucketIndex))
+}
+
+func (h *histogram) valueFromIndex(bucketIndex int, subBucketIndex int) int64 {
+	return int64(subBucketIndex) << (uint(bucketIndex) + h.unitMagnitude)
+}
+
+func (h *histogram) valueFromCountsIndex(i int) int64 {
+	bucketIndex := (i >> h.subBucketHalfCountMagnitude) - 1
+	subBucketIndex := (i & (h.subBucketHalfCount - 1)) + h.subBucketHalfCount
+	if bucketIndex < 0 {
+		subBucketIndex -= h.subBucketHalfCount
+		bucketIndex = 0
+	}
+	return h.valueFromIndex(bucketIndex, subBucketIndex)
+}
+
+func (h *histogram) sizeOfEquivalentValueRange(v int64) int64 {
+	bucketIndex := h.bucketIndex(v)
+	subBucketIndex := h.subBucketIndex(v, bucketIndex)
+	adjustedBucket := bucketIndex
+	if subBucketIndex >= h.subBucketCount {
+		adjustedBucket++
+	}
+	return int64(1) << (h.unitMagnitude + uint(adjustedBucket))
+}
+
+func (h *histogram) lowestEquivalentValue(v int64) int64 {
+	bucketIndex := h.bucketIndex(v)
+	return h.valueFromIndex(bucketIndex, h.subBucketIndex(v, bucketIndex))
+}
+
+func (h *histogram) highestEquivalentValue(v int64) int64 {
+	return h.lowestEquivalentValue(v) + h.sizeOfEquivalentValueRange(v) - 1
+}
+
+func (h *histogram) medianEquivalentValue(v int64) int64 {
+	return h.lowestEquivalentValue(v) + h.sizeOfEquivalentValueRange(v)>>1
+}
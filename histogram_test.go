@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHistogramEmpty(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+
+	// Act
+	p := h.valueAtPercentile(99)
+	max := h.max()
+
+	// Assert
+	if p != 0 {
+		t.Fatalf("Unexpected p: %d", p)
+	}
+	if max != 0 {
+		t.Fatalf("Unexpected max: %d", max)
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+
+	// Act
+	for v := int64(1); v <= 10000; v++ {
+		h.record(v)
+	}
+
+	// Assert
+	expected := map[float64]int64{50: 5000, 99: 9900, 99.9: 9990, 100: 10000}
+	for percentile, e := range expected {
+		v := h.valueAtPercentile(percentile)
+		if v < e || v > e+e/1000 {
+			t.Fatalf("Unexpected value at percentile %v: %d", percentile, v)
+		}
+	}
+	if h.totalCount != 10000 {
+		t.Fatalf("Unexpected totalCount: %d", h.totalCount)
+	}
+}
+
+func TestHistogramExactBelowSubBucketCount(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+
+	// Act
+	h.record(7)
+	h.record(1000)
+
+	// Assert
+	if h.valueAtPercentile(50) != 7 {
+		t.Fatalf("Unexpected p50: %d", h.valueAtPercentile(50))
+	}
+	if h.max() != 1000 {
+		t.Fatalf("Unexpected max: %d", h.max())
+	}
+}
+
+func TestHistogramClampsOutOfRange(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 1000000, 2)
+
+	// Act
+	h.record(-5)
+	h.record(5000000)
+
+	// Assert
+	if h.valueAtPercentile(0) != 0 {
+		t.Fatalf("Unexpected min: %d", h.valueAtPercentile(0))
+	}
+	if h.maxValue != 1000000 {
+		t.Fatalf("Unexpected maxValue: %d", h.maxValue)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	// Arrange
+	h1 := newHistogram(1, 3600000000, 3)
+	h2 := h1.newEmptyCopy()
+	for v := int64(1); v <= 100; v++ {
+		h1.record(v)
+		h2.record(v + 100)
+	}
+
+	// Act
+	h1.merge(h2)
+
+	// Assert
+	if h1.totalCount != 200 {
+		t.Fatalf("Unexpected totalCount: %d", h1.totalCount)
+	}
+	if h1.valueAtPercentile(50) != 100 {
+		t.Fatalf("Unexpected p50: %d", h1.valueAtPercentile(50))
+	}
+	if h1.max() != 200 || h1.min() != 1 {
+		t.Fatalf("Unexpected min/max: %d/%d", h1.min(), h1.max())
+	}
+}
+
+func TestZigZagVarint(t *testing.T) {
+	// Arrange
+	cases := []struct {
+		v        int64
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x02}},
+		{-1, []byte{0x01}},
+		{-3, []byte{0x05}},
+		{64, []byte{0x80, 0x01}},
+	}
+
+	for _, c := range cases {
+		// Act
+		var buf [9]byte
+		n := putZigZagVarint(buf[:], c.v)
+
+		// Assert
+		if !bytes.Equal(buf[:n], c.expected) {
+			t.Fatalf("Unexpected encoding of %d: %x", c.v, buf[:n])
+		}
+	}
+}
+
+func TestHistogramEncodeRunLengthZeros(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+	h.record(1)
+	h.record(5)
+
+	// Act
+	b := h.encode()
+
+	// Assert
+	// Header is 40 bytes, followed by: index 0 has count 0, index 1 has count 1, indexes 2-4 are a run of 3 zeros, index 5 has count 1.
+	expectedPayload := []byte{0x00, 0x02, 0x05, 0x02}
+	if !bytes.Equal(b[40:], expectedPayload) {
+		t.Fatalf("Unexpected payload: %x", b[40:])
+	}
+	if b[7] != byte(len(expectedPayload)) {
+		t.Fatalf("Unexpected payload length: %d", b[7])
+	}
+}
+
+func TestHistogramPercentileDistribution(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+	for v := int64(1000); v <= 2000; v++ {
+		h.record(v)
+	}
+	var buf bytes.Buffer
+
+	// Act
+	err := h.writePercentileDistribution(&buf, 1000)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error %T: %v", err, err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.Contains(lines[0], "Value") || !strings.Contains(lines[0], "1/(1-Percentile)") {
+		t.Fatalf("Unexpected header: %v", lines[0])
+	}
+	if !strings.Contains(buf.String(), "       2.000 1.000000000000       1001\n") {
+		t.Fatalf("Unexpected last line in: %v", buf.String())
+	}
+	if !strings.Contains(buf.String(), "#[Max     =        2.000, Total count    =         1001]") {
+		t.Fatalf("Unexpected footer in: %v", buf.String())
+	}
+}
+
+func TestHistogramCountAbove(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+	for v := int64(1); v <= 1000; v++ {
+		h.record(v)
+	}
+
+	// Act
+	n := h.countAbove(900)
+
+	// Assert
+	if n != 100 {
+		t.Fatalf("Unexpected count: %d", n)
+	}
+}
+
+func TestHistogramSubtract(t *testing.T) {
+	// Arrange
+	h := newHistogram(1, 3600000000, 3)
+	for v := int64(1); v <= 100; v++ {
+		h.record(v)
+	}
+	snapshot := h.newEmptyCopy()
+	snapshot.merge(h)
+	for v := int64(1001); v <= 1100; v++ {
+		h.record(v)
+	}
+
+	// Act
+	h.subtract(snapshot)
+
+	// Assert
+	if h.totalCount != 100 {
+		t.Fatalf("Unexpected totalCount: %d", h.totalCount)
+	}
+	if h.valueAtPercentile(0) != 1001 {
+		t.Fatalf("Unexpected lowest value: %d", h.valueAtPercentile(0))
+	}
+}
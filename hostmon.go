@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Above these, the client host rather than the target may be what limits a benchmark.
+const (
+	hostCoreBusyWarnPct    = 90   // Mean busy percentage of any one core.
+	hostCoreSoftirqWarnPct = 50   // Mean percentage of any one core spent in softirq, handling network packets.
+	hostRetransWarnPct     = 1    // Share of sent TCP segments that were retransmitted.
+	hostGCPauseWarnMs      = 10.0 // Longest GC pause of hlg itself.
+)
+
+// Cumulative counters of the client host, read from /proc.
+type hostCounters struct {
+	cpus            []cpuTimes // Per core, in clock ticks.
+	ctxSwitches     uint64
+	tcpOutSegs      uint64
+	tcpRetransSegs  uint64
+	listenDrops     uint64
+	listenOverflows uint64
+	sockets         uint64 // Sockets in use, a gauge.
+	tcpInUse        uint64 // TCP sockets in use, a gauge.
+	tcpTimeWait     uint64 // TCP sockets in TIME_WAIT, a gauge.
+}
+
+// Cumulative GC counters of hlg itself. Reading them stops the world, so they are only read before and after a
+// benchmark, and not with the counters of each interval.
+type gcCounters struct {
+	numGC   uint32
+	pauseNs [256]uint64 // Recent GC pauses, as in runtime.MemStats.
+}
+
+type cpuTimes struct {
+	busy    uint64 // All but idle and iowait.
+	softirq uint64
+	total   uint64
+}
+
+// The load on the client host over one interval of the time series.
+type hostInterval struct {
+	CPUBusyPct      []float64 `json:"cpuBusyPct"`    // Per core.
+	CPUSoftirqPct   []float64 `json:"cpuSoftirqPct"` // Per core, part of the busy percentage.
+	CtxSwitches     uint64    `json:"ctxSwitches"`
+	TCPOutSegs      uint64    `json:"tcpOutSegs"`
+	TCPRetransSegs  uint64    `json:"tcpRetransSegs"`
+	ListenDrops     uint64    `json:"listenDrops"`
+	ListenOverflows uint64    `json:"listenOverflows"`
+	Sockets         uint64    `json:"sockets"`     // At the end of the interval.
+	TCPInUse        uint64    `json:"tcpInUse"`    // At the end of the interval.
+	TCPTimeWait     uint64    `json:"tcpTimeWait"` // At the end of the interval.
+}
+
+// The load on the client host over the measured part of a benchmark.
+type hostLoad struct {
+	CPUBusyPct        float64   `json:"cpuBusyPct"`        // Mean over all cores.
+	CoreBusyPct       []float64 `json:"coreBusyPct"`       // Mean per core.
+	CoreSoftirqPct    []float64 `json:"coreSoftirqPct"`    // Mean per core.
+	CtxSwitchesPerSec float64   `json:"ctxSwitchesPerSec"` // Across all cores.
+	TCPRetransSegs    uint64    `json:"tcpRetransSegs"`
+	TCPRetransPct     float64   `json:"tcpRetransPct"` // Of the TCP segments sent.
+	ListenDrops       uint64    `json:"listenDrops"`
+	ListenOverflows   uint64    `json:"listenOverflows"`
+	MaxTCPInUse       uint64    `json:"maxTCPInUse"`
+	MaxTCPTimeWait    uint64    `json:"maxTCPTimeWait"`
+	GCs               uint32    `json:"gcs"`          // Of hlg over the whole benchmark, warmup included.
+	GCPauseMs         float64   `json:"gcPauseMs"`    // Total of the GC pauses of hlg over the whole benchmark.
+	GCMaxPauseMs      float64   `json:"gcMaxPauseMs"` // Longest GC pause of hlg over the whole benchmark.
+	Warnings          []string  `json:"warnings"`     // Signs that the client host was saturated.
+
+	seconds    float64
+	tcpOutSegs uint64
+}
+
+// Reads the counters of the client host. Fails if /proc is not readable, as outside Linux.
+func readHostCounters() (c *hostCounters, err error) {
+	c = &hostCounters{}
+
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return
+	}
+	c.cpus, c.ctxSwitches, err = parseProcStat(data)
+	if err != nil {
+		return
+	}
+
+	data, err = ioutil.ReadFile("/proc/net/snmp")
+	if err != nil {
+		return
+	}
+	snmp := parseProcNetTable(data)
+	c.tcpOutSegs = snmp["Tcp"]["OutSegs"]
+	c.tcpRetransSegs = snmp["Tcp"]["RetransSegs"]
+
+	data, err = ioutil.ReadFile("/proc/net/netstat")
+	if err != nil {
+		return
+	}
+	netstat := parseProcNetTable(data)
+	c.listenDrops = netstat["TcpExt"]["ListenDrops"]
+	c.listenOverflows = netstat["TcpExt"]["ListenOverflows"]
+
+	data, err = ioutil.ReadFile("/proc/net/sockstat")
+	if err != nil {
+		return
+	}
+	sockstat := parseSockstat(data)
+	c.sockets = sockstat["sockets"]["used"]
+	c.tcpInUse = sockstat["TCP"]["inuse"]
+	c.tcpTimeWait = sockstat["TCP"]["tw"]
+	return
+}
+
+func readGCCounters() (c *gcCounters) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	c = &gcCounters{numGC: ms.NumGC, pauseNs: ms.PauseNs}
+	return
+}
+
+// Parses the per-core CPU times and the number of context switches from /proc/stat.
+func parseProcStat(data []byte) (cpus []cpuTimes, ctxSwitches uint64, err error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		if fields[0] == "ctxt" {
+			ctxSwitches, err = strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		// Per-core lines are cpu0, cpu1 and so on. The cpu line without a number is the total.
+		if !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		if len(fields) < 9 {
+			err = fmt.Errorf("too few fields for %s in /proc/stat", fields[0])
+			return
+		}
+
+		// user nice system idle iowait irq softirq steal. Guest time is already part of user.
+		var ticks [8]uint64
+		for i := range ticks {
+			ticks[i], err = strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return
+			}
+		}
+		var t cpuTimes
+		for _, n := range ticks {
+			t.total += n
+		}
+		t.busy = t.total - ticks[3] - ticks[4]
+		t.softirq = ticks[6]
+		cpus = append(cpus, t)
+	}
+	err = sc.Err()
+	return
+}
+
+// Parses the tables of /proc/net/snmp and /proc/net/netstat, where each table is a line of names followed by a line of
+// values, both starting with the name of the table, such as "Tcp:". Returns the values by table and name.
+func parseProcNetTable(data []byte) map[string]map[string]uint64 {
+	tables := make(map[string]map[string]uint64)
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i+1 < len(lines); i += 2 {
+		names := strings.Fields(lines[i])
+		values := strings.Fields(lines[i+1])
+		if len(names) == 0 || len(names) != len(values) || names[0] != values[0] {
+			continue
+		}
+
+		table := make(map[string]uint64)
+		for j := 1; j < len(names); j++ {
+			// Some values, such as the max connections of Tcp, are -1, and are left out.
+			n, err := strconv.ParseUint(values[j], 10, 64)
+			if err == nil {
+				table[names[j]] = n
+			}
+		}
+		tables[strings.TrimSuffix(names[0], ":")] = table
+	}
+	return tables
+}
+
+// Parses /proc/net/sockstat, where each line is the name of a protocol followed by pairs of names and values, as in
+// "TCP: inuse 5 orphan 0 tw 2". Returns the values by protocol and name.
+func parseSockstat(data []byte) map[string]map[string]uint64 {
+	protocols := make(map[string]map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		values := make(map[string]uint64)
+		for j := 1; j+1 < len(fields); j += 2 {
+			n, err := strconv.ParseUint(fields[j+1], 10, 64)
+			if err == nil {
+				values[fields[j]] = n
+			}
+		}
+		protocols[strings.TrimSuffix(fields[0], ":")] = values
+	}
+	return protocols
+}
+
+// The load on the client host between two readings of its counters.
+func (c *hostCounters) since(prev *hostCounters) (h hostInterval) {
+	for i := 0; i < len(c.cpus) && i < len(prev.cpus); i++ {
+		var busy, softirq float64
+		if total := c.cpus[i].total - prev.cpus[i].total; total > 0 {
+			busy = 100 * float64(c.cpus[i].busy-prev.cpus[i].busy) / float64(total)
+			softirq = 100 * float64(c.cpus[i].softirq-prev.cpus[i].softirq) / float64(total)
+		}
+		h.CPUBusyPct = append(h.CPUBusyPct, busy)
+		h.CPUSoftirqPct = append(h.CPUSoftirqPct, softirq)
+	}
+
+	h.CtxSwitches = c.ctxSwitches - prev.ctxSwitches
+	h.TCPOutSegs = c.tcpOutSegs - prev.tcpOutSegs
+	h.TCPRetransSegs = c.tcpRetransSegs - prev.tcpRetransSegs
+	h.ListenDrops = c.listenDrops - prev.listenDrops
+	h.ListenOverflows = c.listenOverflows - prev.listenOverflows
+	h.Sockets = c.sockets
+	h.TCPInUse = c.tcpInUse
+	h.TCPTimeWait = c.tcpTimeWait
+	return
+}
+
+// The number of GCs between two readings, with their total and longest pause. The runtime only keeps the most recent
+// pauses, so the pauses of all but the last 256 GCs are missed.
+func (c *gcCounters) since(prev *gcCounters) (gcs uint32, pauseMs float64, maxPauseMs float64) {
+	gcs = c.numGC - prev.numGC
+	for i := uint32(0); i < gcs && i < uint32(len(c.pauseNs)); i++ {
+		ms := float64(c.pauseNs[(c.numGC-1-i)%uint32(len(c.pauseNs))]) / 1e6
+		pauseMs += ms
+		if ms > maxPauseMs {
+			maxPauseMs = ms
+		}
+	}
+	return
+}
+
+// Mean busy percentage over all cores.
+func (h *hostInterval) cpuBusyPct() float64 {
+	return meanFloat(h.CPUBusyPct)
+}
+
+// Busy percentage of the busiest core.
+func (h *hostInterval) maxCoreBusyPct() float64 {
+	return maxFloat(h.CPUBusyPct)
+}
+
+// Softirq percentage of the core that spent the most time in softirq.
+func (h *hostInterval) maxCoreSoftirqPct() float64 {
+	return maxFloat(h.CPUSoftirqPct)
+}
+
+// Adds an interval of the given length to the load of the benchmark.
+func (l *hostLoad) add(h *hostInterval, seconds float64) {
+	for len(l.CoreBusyPct) < len(h.CPUBusyPct) {
+		l.CoreBusyPct = append(l.CoreBusyPct, 0)
+		l.CoreSoftirqPct = append(l.CoreSoftirqPct, 0)
+	}
+	// Summed weighted by the length of the interval until finish turns them into means.
+	for i := range h.CPUBusyPct {
+		l.CoreBusyPct[i] += h.CPUBusyPct[i] * seconds
+		l.CoreSoftirqPct[i] += h.CPUSoftirqPct[i] * seconds
+	}
+	l.CtxSwitchesPerSec += float64(h.CtxSwitches)
+	l.seconds += seconds
+
+	l.tcpOutSegs += h.TCPOutSegs
+	l.TCPRetransSegs += h.TCPRetransSegs
+	l.ListenDrops += h.ListenDrops
+	l.ListenOverflows += h.ListenOverflows
+	if h.TCPInUse > l.MaxTCPInUse {
+		l.MaxTCPInUse = h.TCPInUse
+	}
+	if h.TCPTimeWait > l.MaxTCPTimeWait {
+		l.MaxTCPTimeWait = h.TCPTimeWait
+	}
+}
+
+// Turns the sums of add into means and rates, and warns of signs that the client host was saturated.
+func (l *hostLoad) finish() {
+	if l.seconds > 0 {
+		for i := range l.CoreBusyPct {
+			l.CoreBusyPct[i] /= l.seconds
+			l.CoreSoftirqPct[i] /= l.seconds
+		}
+		l.CtxSwitchesPerSec /= l.seconds
+	}
+	l.CPUBusyPct = meanFloat(l.CoreBusyPct)
+	if l.tcpOutSegs > 0 {
+		l.TCPRetransPct = 100 * float64(l.TCPRetransSegs) / float64(l.tcpOutSegs)
+	}
+
+	l.Warnings = []string{}
+	for i, pct := range l.CoreBusyPct {
+		if pct >= hostCoreBusyWarnPct {
+			l.Warnings = append(l.Warnings, fmt.Sprintf("cpu%d of the client was %.0f%% busy", i, pct))
+		}
+	}
+	for i, pct := range l.CoreSoftirqPct {
+		if pct >= hostCoreSoftirqWarnPct {
+			l.Warnings = append(l.Warnings, fmt.Sprintf("cpu%d of the client spent %.0f%% of its time handling network packets in softirq; spread interrupts over more cores", i, pct))
+		}
+	}
+	if l.TCPRetransPct >= hostRetransWarnPct {
+		l.Warnings = append(l.Warnings, fmt.Sprintf("%.1f%% of the TCP segments sent by the client host were retransmitted", l.TCPRetransPct))
+	}
+	if l.ListenDrops > 0 {
+		// Overflows of the accept queue are counted as drops as well.
+		l.Warnings = append(l.Warnings, fmt.Sprintf("%d connections were dropped by listen queues on the client host", l.ListenDrops))
+	}
+	if l.GCMaxPauseMs >= hostGCPauseWarnMs {
+		l.Warnings = append(l.Warnings, fmt.Sprintf("hlg paused for up to %.1fms for garbage collection", l.GCMaxPauseMs))
+	}
+}
+
+func maxFloat(xs []float64) (max float64) {
+	for _, x := range xs {
+		if x > max {
+			max = x
+		}
+	}
+	return
+}
+
+func meanFloat(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	// Arrange
+	data := []byte(`cpu  300 0 100 500 100 0 50 0 0 0
+cpu0 200 0 50 200 50 0 40 0 0 0
+cpu1 100 0 50 300 50 0 10 0 0 0
+intr 12345 0 0
+ctxt 98765
+btime 1700000000
+`)
+
+	// Act
+	cpus, ctxSwitches, err := parseProcStat(data)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if len(cpus) != 2 {
+		t.Fatalf("Unexpected cpus: %v", cpus)
+	}
+	if cpus[0] != (cpuTimes{busy: 290, softirq: 40, total: 540}) {
+		t.Fatalf("Unexpected cpu0: %+v", cpus[0])
+	}
+	if ctxSwitches != 98765 {
+		t.Fatalf("Unexpected ctxSwitches: %d", ctxSwitches)
+	}
+}
+
+func TestParseProcNetTable(t *testing.T) {
+	// Arrange
+	data := []byte(`Ip: Forwarding DefaultTTL
+Ip: 1 64
+Tcp: RtoAlgorithm MaxConn OutSegs RetransSegs
+Tcp: 1 -1 1000 7
+`)
+
+	// Act
+	tables := parseProcNetTable(data)
+
+	// Assert
+	if tables["Tcp"]["OutSegs"] != 1000 || tables["Tcp"]["RetransSegs"] != 7 {
+		t.Fatalf("Unexpected Tcp: %v", tables["Tcp"])
+	}
+	if _, ok := tables["Tcp"]["MaxConn"]; ok {
+		t.Fatalf("Unexpected MaxConn: %v", tables["Tcp"])
+	}
+	if tables["Ip"]["DefaultTTL"] != 64 {
+		t.Fatalf("Unexpected Ip: %v", tables["Ip"])
+	}
+}
+
+func TestParseSockstat(t *testing.T) {
+	// Arrange
+	data := []byte(`sockets: used 22
+TCP: inuse 7 orphan 0 tw 6536 alloc 7 mem 0
+`)
+
+	// Act
+	protocols := parseSockstat(data)
+
+	// Assert
+	if protocols["sockets"]["used"] != 22 {
+		t.Fatalf("Unexpected sockets: %v", protocols["sockets"])
+	}
+	if protocols["TCP"]["inuse"] != 7 || protocols["TCP"]["tw"] != 6536 {
+		t.Fatalf("Unexpected TCP: %v", protocols["TCP"])
+	}
+}
+
+func TestHostCountersSince(t *testing.T) {
+	// Arrange
+	prev := &hostCounters{cpus: []cpuTimes{{busy: 100, softirq: 10, total: 1000}}}
+	cur := &hostCounters{cpus: []cpuTimes{{busy: 150, softirq: 30, total: 1100}}, ctxSwitches: 50}
+
+	// Act
+	h := cur.since(prev)
+
+	// Assert
+	if h.CPUBusyPct[0] != 50 || h.CPUSoftirqPct[0] != 20 {
+		t.Fatalf("Unexpected cpu: %v %v", h.CPUBusyPct, h.CPUSoftirqPct)
+	}
+	if h.CtxSwitches != 50 {
+		t.Fatalf("Unexpected CtxSwitches: %d", h.CtxSwitches)
+	}
+}
+
+func TestGCCountersSince(t *testing.T) {
+	// Arrange
+	prev := &gcCounters{numGC: 1}
+	prev.pauseNs[0] = 5e6
+	cur := &gcCounters{numGC: 3}
+	cur.pauseNs[0] = 5e6
+	cur.pauseNs[1] = 1e6
+	cur.pauseNs[2] = 2e6
+
+	// Act
+	gcs, pauseMs, maxPauseMs := cur.since(prev)
+
+	// Assert
+	if gcs != 2 || pauseMs != 3 || maxPauseMs != 2 {
+		t.Fatalf("Unexpected GC: %d %v %v", gcs, pauseMs, maxPauseMs)
+	}
+}
+
+func TestHostLoadWarnings(t *testing.T) {
+	// Arrange
+	l := &hostLoad{}
+
+	// Act
+	l.add(&hostInterval{CPUBusyPct: []float64{100, 20}, CPUSoftirqPct: []float64{60, 0}, TCPOutSegs: 100, TCPRetransSegs: 5}, 1)
+	l.add(&hostInterval{CPUBusyPct: []float64{90, 20}, CPUSoftirqPct: []float64{50, 0}, TCPOutSegs: 100}, 1)
+	l.finish()
+
+	// Assert
+	if l.CoreBusyPct[0] != 95 || l.CPUBusyPct != 57.5 {
+		t.Fatalf("Unexpected busy: %v %v", l.CoreBusyPct, l.CPUBusyPct)
+	}
+	if l.TCPRetransPct != 2.5 {
+		t.Fatalf("Unexpected TCPRetransPct: %v", l.TCPRetransPct)
+	}
+	warnings := strings.Join(l.Warnings, "\n")
+	if len(l.Warnings) != 3 || !strings.Contains(warnings, "cpu0") || strings.Contains(warnings, "cpu1") {
+		t.Fatalf("Unexpected Warnings: %v", l.Warnings)
+	}
+}
@@ -17,11 +17,32 @@ import (
 )
 
 func main() {
-	go func() {
-		http.ListenAndServe(":6060", nil)
-	}()
+	// Subcommands come before any flags.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compare":
+			os.Exit(runCompare(os.Args[2:]))
+		case "report":
+			os.Exit(runReport(os.Args[2:]))
+		case "dump":
+			os.Exit(runDump(os.Args[2:]))
+		}
+	}
 
-	ipv4, port, reqBytes, seconds, maxp99d99ms, maxp99d999ms, maxp100ms, rps, timeout, maxConcurrent := processCmdLine()
+	cfg, reqBytes, rps, srch, swp, checkpointFile, resume, listenAddr, outDirParent := processCmdLine()
+	defer finishRequests(cfg.requests)
+
+	// Serve pprof and live metrics.
+	if listenAddr != "" {
+		http.Handle("/metrics", liveMetrics)
+		go func() {
+			err := http.ListenAndServe(listenAddr, nil)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to serve metrics on %v: %v\n", listenAddr, err)
+			}
+		}()
+	}
+	manifest := newRunManifest(cfg, rps, srch, swp, time.Now())
 
 	req, err := newHttpReq(reqBytes)
 	if err != nil {
@@ -29,80 +50,404 @@ func main() {
 		return
 	}
 
+	// Each run gets a directory of its own, except that a resumed search continues in the latest one.
+	var out *outputDir
+	if outDirParent != "" {
+		if resume {
+			out, err = openLatestOutputDir(outDirParent)
+		} else {
+			out, err = newOutputDir(outDirParent, manifest.StartTime)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+
+		checkpointFile = out.path(checkpointFile)
+		cfg.resultsFile = out.path(cfg.resultsFile)
+		if !resume {
+			err = out.writeConfig(manifest)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return
+			}
+		}
+		if cfg.output != "json" {
+			fmt.Printf("Writing results to %s\n", out.root)
+		}
+	}
+
+	// A resumed search keeps appending to the time series and results of the earlier run. With run directories, each
+	// test has a time series of its own.
+	if cfg.timeSeriesFile != "" && !resume && out == nil {
+		err = createTimeSeriesFile(cfg.timeSeriesFile, cfg.percentiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+	}
+	if cfg.output == "json" && rps == 0 && !resume {
+		err = ioutil.WriteFile(cfg.resultsFile, nil, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+	}
+
 	// Disable garbage collection for less chance of random variation, and trigger it manually going forward.
 	//debug.SetGCPercent(-1)
 
 	if rps != 0 {
-		fmt.Printf("Running with %v requests/sec\n", rps)
-		b := NewBenchmark(req, ipv4, port, seconds, rps, timeout, maxConcurrent, true)
-		_, err = b.Start()
+		verbose := cfg.output != "json"
+		if verbose {
+			fmt.Printf("Running with %v requests/sec\n", rps)
+		}
+		stepCfg, stepDir, err := out.nextStep(cfg, rps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+		b := NewBenchmark(req, stepCfg, rps, verbose)
+		r, err := b.Start()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+
+		err = out.writeStepSummary(stepDir, manifest, rps, &r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return
+		}
+
+		if cfg.output == "json" {
+			err = writeJSONResult(os.Stdout, manifest, rps, &r)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return
+			}
+		}
+	} else if swp != nil {
+		err = runSweep(req, cfg, swp, manifest, out)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			return
 		}
 	} else {
-		fmt.Printf("Starting...\n")
-		ioutil.WriteFile("hillclimb.csv", []byte("rps,errors,p99d99\n"), 0644)
-		rps = 1000
-		a := 0.5
+		var history []hillClimbStep
+		if resume {
+			var sloText string
+			var percentiles []float64
+			srch, sloText, percentiles, history, err = loadHillClimbCheckpoint(checkpointFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+
+			err = checkResumable(sloText, percentiles, cfg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot resume: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Resuming %v search with SLO %v at rps %d (%v)\n", srch.strategy, cfg.slo, srch.rps, srch)
+		} else {
+			fmt.Printf("Starting with SLO %v\n", cfg.slo)
+			err = writeHillClimbHeader(out.path("hillclimb.csv"), cfg.percentiles)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return
+			}
+		}
+
+		p, baseline := startRecoveryProbe(cfg, req)
+
 		for {
-			b := NewBenchmark(req, ipv4, port, seconds, rps, timeout, maxConcurrent, false)
+			liveMetrics.searchUpdated(srch)
+			if cfg.dashboard != nil {
+				cfg.dashboard.searchUpdated(srch, history)
+			}
+			if done, exitCode := srch.done(); done {
+				reportSearchResult(srch, exitCode)
+				finishRequests(cfg.requests)
+				os.Exit(exitCode)
+			}
+
+			rps = srch.rps
+			stepCfg, stepDir, err := out.nextStep(cfg, rps)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return
+			}
+			if out == nil {
+				stepCfg = numberStepFiles(stepCfg, len(history)+1, rps)
+			}
+			b := NewBenchmark(req, stepCfg, rps, false)
 			r, err := b.Start()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 				return
 			}
 
-			p99d99ms := float64(r.p99d99) / float64(time.Millisecond)
-			p99d999ms := float64(r.p99d999) / float64(time.Millisecond)
-			p100ms := float64(r.max) / float64(time.Millisecond)
-			fmt.Printf("rps: %6d, errors: %6d, p99d99ms: %9.2f, p99d999ms: %9.2f, p100ms: %9.2f\n", rps, r.errors, p99d99ms, p99d999ms, p100ms)
+			fmt.Printf("rps: %6d, errors: %6d", rps, r.errors)
+			for _, p := range r.percentiles {
+				fmt.Printf(", %sms: %9.2f", percentileName(p), float64(r.latencyAtPercentile(p))/float64(time.Millisecond))
+			}
+			if cfg.adaptive {
+				fmt.Printf(", seconds: %4.0f, samples: %8d", r.seconds, r.hist.totalCount)
+			}
+			if r.aborted {
+				fmt.Printf(", aborted after %.1fs: %s", r.seconds, strings.Join(r.sloViolations, ", "))
+			}
+			if r.saturated {
+				fmt.Printf(", invalid: hlg fell behind, p99 send lag %.2fms", durationMs(r.sendLag.p99))
+			}
+			fmt.Printf("\n")
 
 			// Write progress to a file.
-			f, err := os.OpenFile("hillclimb.csv", os.O_APPEND|os.O_WRONLY, 0644)
+			err = appendHillClimbRow(out.path("hillclimb.csv"), rps, &r)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 				return
 			}
-			_, err = fmt.Fprintf(f, "%d,%d,%f\n", rps, r.errors, p99d99ms)
+
+			err = out.writeStepSummary(stepDir, manifest, rps, &r)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 				return
 			}
-			err = f.Close()
+
+			if cfg.output == "json" {
+				err = appendJSONResult(cfg.resultsFile, manifest, rps, &r)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+					return
+				}
+			}
+
+			if r.saturated {
+				srch.recordClientLimit()
+			} else {
+				srch.record(r.sloPass)
+			}
+
+			history = append(history, newHillClimbStep(rps, &r))
+			err = saveHillClimbCheckpoint(checkpointFile, srch, cfg.slo, cfg.percentiles, history)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 				return
 			}
 
-			if r.errors == 0 && r.p99d99 <= maxp99d99ms && r.p99d999 <= maxp99d999ms && r.max <= maxp100ms {
-				rps = int(float64(rps) + float64(rps)*a)
-			} else {
-				rps = int(float64(rps) - float64(rps)*a)
-				a = a * 0.9
+			if !r.sloPass || r.errors > 0 {
+				awaitRecovery(p, baseline, cfg)
+			}
+		}
+	}
+}
+
+// Measures the baseline latency of the target for the recovery waits between tests. If that fails, the tests run
+// without recovery waits, and a target that is down shows up as failing tests rather than as no tests at all.
+func startRecoveryProbe(cfg benchmarkConfig, req *reqPayload) (p *prober, baseline time.Duration) {
+	if cfg.recoveryMaxWait == 0 {
+		return
+	}
+
+	p = newProber(cfg, req, cfg.probeInterval)
+	baseline, err := p.baseline(20)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to measure baseline latency, continuing without waiting for recovery: %v\n", err)
+		p = nil
+		return
+	}
+
+	fmt.Printf("Baseline probe latency: %.2fms\n", float64(baseline)/float64(time.Millisecond))
+	return
+}
+
+func awaitRecovery(p *prober, baseline time.Duration, cfg benchmarkConfig) {
+	if p == nil {
+		return
+	}
+
+	recovered, waited := p.waitForRecovery(baseline, cfg.recoveryFactor, cfg.recoveryMaxWait)
+	if recovered {
+		fmt.Printf("Target recovered after %.1fs\n", waited.Seconds())
+	} else {
+		fmt.Printf("Target did not recover within %.1fs, continuing anyway\n", waited.Seconds())
+	}
+}
+
+// Runs a benchmark at each rps of the sweep, writes the results to sweep.csv and the chart to sweep.svg, and
+// points out the knee of the latency curve.
+func runSweep(req *reqPayload, cfg benchmarkConfig, swp *sweep, manifest runManifest, out *outputDir) (err error) {
+	fmt.Printf("Sweeping %d rps values with SLO %v\n", len(swp.rpss), cfg.slo)
+	err = writeSweepHeader(out.path("sweep.csv"), cfg.percentiles)
+	if err != nil {
+		return
+	}
+
+	p, baseline := startRecoveryProbe(cfg, req)
+
+	var points []sweepPoint
+	step := 0
+	for _, rps := range swp.rpss {
+		var results []BenchmarkResult
+		for repeat := 0; repeat < swp.repeats; repeat++ {
+			var stepCfg benchmarkConfig
+			var stepDir string
+			stepCfg, stepDir, err = out.nextStep(cfg, rps)
+			if err != nil {
+				return
+			}
+			step++
+			if out == nil {
+				stepCfg = numberStepFiles(stepCfg, step, rps)
+			}
+			b := NewBenchmark(req, stepCfg, rps, false)
+			var r BenchmarkResult
+			r, err = b.Start()
+			if err != nil {
+				return
+			}
+
+			fmt.Printf("rps: %6d, recvdRate: %9.2f, errors: %6d", rps, r.recvdRate, r.errors)
+			for _, pct := range r.percentiles {
+				fmt.Printf(", %sms: %9.2f", percentileName(pct), float64(r.latencyAtPercentile(pct))/float64(time.Millisecond))
+			}
+			if cfg.adaptive {
+				fmt.Printf(", seconds: %4.0f, samples: %8d", r.seconds, r.hist.totalCount)
+			}
+			if r.saturated {
+				fmt.Printf(", invalid: hlg fell behind, p99 send lag %.2fms", durationMs(r.sendLag.p99))
+			}
+			fmt.Printf("\n")
+
+			err = appendSweepRow(out.path("sweep.csv"), rps, repeat, &r)
+			if err != nil {
+				return
 			}
 
-			if r.errors > 0 {
-				time.Sleep(10 * time.Second)
+			err = out.writeStepSummary(stepDir, manifest, rps, &r)
+			if err != nil {
+				return
+			}
+
+			if cfg.output == "json" {
+				err = appendJSONResult(cfg.resultsFile, manifest, rps, &r)
+				if err != nil {
+					return
+				}
+			}
+
+			results = append(results, r)
+
+			if !r.sloPass || r.errors > 0 {
+				awaitRecovery(p, baseline, cfg)
 			}
 		}
+		points = append(points, newSweepPoint(rps, results, cfg.percentiles))
+	}
+
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	for i, pt := range points {
+		xs[i] = float64(pt.rps)
+		ys[i] = pt.latencyMs[swp.kneePercentile]
+	}
+	knee, kneeFound := findKnee(xs, ys)
+	if kneeFound {
+		fmt.Printf("Knee of the %s latency curve at rps %d (%.2fms, recvdRate %.2f)\n", percentileName(swp.kneePercentile), points[knee].rps, ys[knee], points[knee].recvdRate)
+	} else {
+		fmt.Printf("No knee found in the %s latency curve\n", percentileName(swp.kneePercentile))
+	}
+
+	err = writeSweepChart(out.path("sweep.svg"), points, cfg.percentiles, knee, kneeFound)
+	return
+}
+
+// Waits for the per-request results to be written, and reports if writing them failed.
+func finishRequests(w *requestsWriter) {
+	if w == nil {
+		return
+	}
+
+	err := w.close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write per-request results: %v\n", err)
+	}
+}
+
+func reportSearchResult(s *search, exitCode int) {
+	switch exitCode {
+	case exitCodeConverged:
+		fmt.Printf("Converged after %d iterations (%v)\n", s.iterations, s)
+	case exitCodeNotConverged:
+		fmt.Printf("Did not converge within %d iterations (%v)\n", s.maxIterations, s)
+	case exitCodeNoPass:
+		fmt.Printf("No rps met the SLO (%v)\n", s)
+	case exitCodeClientLimit:
+		fmt.Printf("Stopped at rps %d, which hlg itself could not send on time. The target may sustain more; use more CPUs or several load generators (%v)\n", s.clientLimit, s)
 	}
+
+	fmt.Printf("sustainable rps = %d\n", s.sustainableRps())
 }
 
-func processCmdLine() (ipv4 net.IP, port int, reqBytes []byte, seconds int, maxp99d99ms time.Duration, maxp99d999ms time.Duration, maxp100ms time.Duration, rps int, timeout time.Duration, maxConcurrent int) {
+func processCmdLine() (cfg benchmarkConfig, reqBytes []byte, rps int, srch *search, swp *sweep, checkpointFile string, resume bool, listenAddr string, outDir string) {
 	hostArg := flag.String("host", "127.0.0.1", "Target host and optionally port. Example: 127.0.0.1:8080")
 	requestFileArg := flag.String("requestfile", "", "Path to a file containing a full HTTP request in raw form, that will be used for the benchmark.")
 	maxp99d99msArg := flag.Int("maxp99d99ms", 100, "Vary rps until the 99.99th percentile reaches this number of milliseconds.")
 	maxp99d999msArg := flag.Int("maxp99d999ms", 200, "Vary rps until the 99.999th percentile reaches this number of milliseconds.")
 	maxp100msArg := flag.Int("maxp100ms", 500, "Vary rps until the 100th percentile reaches this number of milliseconds.")
+	sloArg := flag.String("slo", "", "Comma separated conditions that a test must meet to pass, such as \"p50<20ms,p99<=150ms,errorrate<0.1%,rps>=1000\". Latency thresholds are durations, or milliseconds if no unit is given. Overrides -maxp99d99ms, -maxp99d999ms and -maxp100ms, which otherwise make up the SLO together with zero errors.")
+	percentilesArg := flag.String("percentiles", "99.9,99.99,99.999", "Comma separated latency percentiles to report. Percentiles used in the SLO are always reported.")
 	rpsArg := flag.Int("rps", 0, "Run at a single constant rate of requests per second instead of varying the rps.")
-	secondsArg := flag.Int("seconds", 60, "Duration of each test in seconds.")
-	timeoutArg := flag.Int("timeoutms", 8000, "Max time in miliseconds to wait for each request to finish before marking it as error and recording the timeout as the time it took.")
-	maxConcurrentArg := flag.Int("maxconcurrent", 45000, "Max number of concurrent requests to allow. If this number of concurrent requests is reached and a new request is supposed to run, the new request will just immediately be marked as error.")
+	searchArg := flag.String("search", "climb", "Strategy for varying the rps. Either \"climb\" to step the rps up and down by a shrinking factor, \"bisect\" to double the rps until the SLO fails and then bisect, or \"noisy\" to bisect while repeating trials near the boundary.")
+	searchToleranceArg := flag.Float64("searchtolerance", 0.02, "Stop varying the rps once the step (climb), or the gap between the highest passing and lowest failing rps (bisect and noisy), is this fraction of the rps.")
+	searchMaxIterationsArg := flag.Int("searchmaxiterations", 100, "Stop varying the rps after this number of tests, even if not converged. 0 means no limit.")
+	sweepArg := flag.String("sweep", "", "Instead of searching for a sustainable rps, run a test at each of these comma separated rps values or start:end:step ranges, such as \"100,200,500:5000:500\". They run in increasing order, each value once. Results go to sweep.csv and a chart to sweep.svg.")
+	sweepRepeatsArg := flag.Int("sweeprepeats", 1, "Number of tests per rps value in a sweep.")
+	kneePercentileArg := flag.Float64("kneepercentile", 99, "Latency percentile whose curve the knee of a sweep is detected on.")
+	earlyAbortArg := flag.Bool("earlyabort", true, "While varying the rps, stop a test as soon as the SLO is certain to fail, instead of running it to the end.")
+	checkpointArg := flag.String("checkpoint", "hillclimb.json", "File where the state of the search is saved after every test, for use with -resume.")
+	resumeArg := flag.Bool("resume", false, "Continue the search from the state saved in the -checkpoint file, appending to hillclimb.csv. The SLO and percentiles must be the same as those of the checkpoint.")
+	searchRepeatsArg := flag.Int("searchrepeats", 3, "Number of tests per rps near the boundary for the noisy search strategy. The majority decides whether the rps passed.")
+	secondsArg := flag.Int("seconds", 60, "Duration of each test in seconds, or the max duration with -adaptive.")
+	adaptiveArg := flag.Bool("adaptive", false, "Run each test until every reported percentile below 100 has enough samples and its 95% confidence interval is within -adaptivetolerance, but at least -minseconds and at most -seconds.")
+	minSecondsArg := flag.Int("minseconds", 10, "Min duration of each test in seconds with -adaptive.")
+	adaptiveToleranceArg := flag.Float64("adaptivetolerance", 0.05, "With -adaptive, a percentile is precise enough once its 95% confidence interval is narrower than this fraction of its upper end.")
+	warmupSecondsArg := flag.Int("warmupseconds", 0, "Duration in seconds of a warmup phase before each test. Requests sent during warmup are excluded from the results.")
+	warmupRpsArg := flag.Int("warmuprps", 0, "Rate of requests per second during the warmup phase. Defaults to the rate of the test itself.")
+	maxSendLagArg := flag.Int("maxsendlagms", 10, "A test is invalid if hlg got to more than 1% of its requests over this number of milliseconds after their planned time, as its own delays would then count as latency. A search stops at such an rps. 0 means no check.")
+	timeoutArg := flag.Int("timeoutms", 8000, "Max time in miliseconds from when each request was planned until its full response, before marking it as error.")
+	connectTimeoutArg := flag.Int("connecttimeoutms", 0, "Max time in miliseconds to establish a connection for a request, before marking it as error. 0 means only -timeoutms applies.")
+	firstByteTimeoutArg := flag.Int("firstbytetimeoutms", 0, "Max time in miliseconds from writing the full request until the first byte of the response, before marking it as error. 0 means only -timeoutms applies.")
+	maxConcurrentArg := flag.Int("maxconcurrent", 45000, "Max number of concurrent requests to allow. What happens when this number of concurrent requests is reached and a new request is supposed to run is decided by -maxconcurrentmode.")
+	maxConcurrentModeArg := flag.String("maxconcurrentmode", "fail", "Either \"fail\" or \"queue\". With fail, a new request that would exceed -maxconcurrent is immediately marked as error. With queue, it waits until a slot frees up, and the time spent waiting is counted in its latency.")
+	connMaxRequestsArg := flag.Int("connmaxrequests", 0, "Close a keep-alive connection after this number of requests. 0 means no limit.")
+	connMaxAgeArg := flag.Int("connmaxagems", 0, "Close a keep-alive connection once it is this number of milliseconds old. 0 means no limit.")
+	connChurnArg := flag.Float64("connchurn", 0, "Fraction between 0 and 1 of keep-alive connections to close at random after each request.")
+	connCloseArg := flag.String("connclose", "fin", "How hlg closes connections. Either \"fin\" for a graceful close or \"rst\" for an abortive close with SO_LINGER set to 0.")
+	retryMaxAttemptsArg := flag.Int("retrymaxattempts", 3, "Max number of times a request is sent, including the first time, when the server closes its connection. Once reached, the request fails.")
+	retryOnArg := flag.String("retryon", "idle", "Comma separated kinds of server-closed connections after which a request is sent again. \"idle\" for a close before any of the response arrived, as when a server closes a keep-alive connection it considers idle, and \"midresponse\" for a close or reset after part of the response arrived. Empty means never retry.")
+	retryAsErrorArg := flag.Bool("retryaserror", false, "Count each request that needed a retry as one error, so that a target that closes connections can fail the SLO even when the retries succeed.")
+	histSigFigsArg := flag.Int("histsigfigs", 3, "Number of significant decimal digits, between 1 and 5, that latency histograms keep.")
+	hdrFileArg := flag.String("hdrfile", "", "If set, write the latency histogram of each test to this file in the HdrHistogram percentile distribution format. A search or sweep writes each test to a file of its own, numbered like latency-003-rps2250.hgrm, or to its step directory with -outdir.")
+	probeIntervalArg := flag.Int("probeintervalms", 100, "Milliseconds between probe requests while waiting for the target to recover after a failed test.")
+	recoveryFactorArg := flag.Float64("recoveryfactor", 1.5, "After a failed test, wait until the median latency of probe requests is within this factor of the baseline measured at the start.")
+	recoveryMaxWaitArg := flag.Int("recoverymaxwaitms", 60000, "Max milliseconds to wait for the target to recover after a failed test. 0 means do not wait or probe at all.")
+	timeSeriesArg := flag.String("timeseries", "", "File to which per-second counters and latency percentiles of every test are appended, for lining up latency spikes with events on the server. Written as JSON lines if the name ends in .json or .jsonl, and as CSV otherwise. Empty means no time series.")
+	outputArg := flag.String("output", "text", "Either \"text\" or \"json\". With json, a single rps run prints its result as a JSON document instead of the summary, and each test of a search or sweep is appended as a JSON line to -resultsfile. Both include a manifest of the host, target and settings.")
+	resultsFileArg := flag.String("resultsfile", "results.jsonl", "File to which each test of a search or sweep is appended as a JSON line with -output json.")
+	requestResultsArg := flag.String("requestresults", "last", "Which per-request results to keep in latencies.bin. Either \"last\" for the last test only, \"all\" for every test of a search or sweep, or \"none\". Each test is written in the background while the next one runs. With -outdir, unless \"none\", each test writes them to its own directory. Convert them to CSV or JSON lines with hlg dump.")
+	outDirArg := flag.String("outdir", "", "If set, each run writes its files to a new directory in this one, named by its start time, with the config, the files of the run as a whole, and a directory per test named by its number and rps with its per-request results, summary and time series. A symlink named latest points to the latest run, which -resume continues in. Empty means all files go to the working directory.")
+	listenArg := flag.String("listen", ":6060", "Address on which to serve live Prometheus metrics on /metrics, and pprof on /debug/pprof. Empty means no server.")
+	dashboardArg := flag.Bool("dashboard", false, "Show a full-screen dashboard of the send rate, concurrency, latency, errors and search progress instead of status lines. Ignored when stdout is not a terminal, and for a single rps test with -output json.")
+	hdrLogFileArg := flag.String("hdrlogfile", "", "If set, write per-second latency histograms of each test to this file in the HdrHistogram interval log format. A search or sweep writes each test to a file of its own, as with -hdrfile.")
 	flag.Parse()
 
 	// Default to port 80 if no port was given.
-	port = 80
+	cfg.port = 80
+	cfg.host = *hostArg
 	host := *hostArg
 	if strings.Contains(host, ":") {
 		h := strings.Split(host, ":")
@@ -113,7 +458,7 @@ func processCmdLine() (ipv4 net.IP, port int, reqBytes []byte, seconds int, maxp
 
 		host = h[0]
 		var err error
-		port, err = strconv.Atoi(h[1])
+		cfg.port, err = strconv.Atoi(h[1])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Invalid port: %v\n", host)
 			os.Exit(1)
@@ -125,7 +470,7 @@ func processCmdLine() (ipv4 net.IP, port int, reqBytes []byte, seconds int, maxp
 		fmt.Fprintf(os.Stderr, "Failed to look up IPv4 address for %v: %v\n", host, err)
 		os.Exit(1)
 	}
-	ipv4 = ips[0].To4()
+	cfg.ipv4 = ips[0].To4()
 
 	// If file arg given, then load req from file. Else use default req.
 	reqBytes = defaultReqBytes
@@ -138,19 +483,240 @@ func processCmdLine() (ipv4 net.IP, port int, reqBytes []byte, seconds int, maxp
 		}
 	}
 
-	maxp99d99ms = time.Duration(*maxp99d99msArg) * time.Millisecond
-
-	maxp99d999ms = time.Duration(*maxp99d999msArg) * time.Millisecond
+	sloText := *sloArg
+	if sloText == "" {
+		sloText = fmt.Sprintf("p99.99<=%dms,p99.999<=%dms,p100<=%dms,errors<=0", *maxp99d99msArg, *maxp99d999msArg, *maxp100msArg)
+	}
+	cfg.slo, err = parseSlo(sloText)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid slo: %v\n", err)
+		os.Exit(1)
+	}
 
-	maxp100ms = time.Duration(*maxp100msArg) * time.Millisecond
+	cfg.percentiles, err = parsePercentiles(*percentilesArg, cfg.slo.percentiles())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid percentiles: %v\n", err)
+		os.Exit(1)
+	}
 
 	rps = *rpsArg
 
-	seconds = *secondsArg
+	strategy, ok := searchStrategyNames[*searchArg]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Invalid search: %v\n", *searchArg)
+		os.Exit(1)
+	}
+	if *searchToleranceArg < 0 || *searchToleranceArg >= 1 {
+		fmt.Fprintf(os.Stderr, "Invalid searchtolerance: %v\n", *searchToleranceArg)
+		os.Exit(1)
+	}
+	if *searchMaxIterationsArg < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid searchmaxiterations: %v\n", *searchMaxIterationsArg)
+		os.Exit(1)
+	}
+	if *searchRepeatsArg < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid searchrepeats: %v\n", *searchRepeatsArg)
+		os.Exit(1)
+	}
+	srch = newSearch(strategy, 1000, *searchToleranceArg, *searchMaxIterationsArg, *searchRepeatsArg)
+
+	if *sweepArg != "" {
+		swp = &sweep{repeats: *sweepRepeatsArg, kneePercentile: *kneePercentileArg}
+		swp.rpss, err = parseSweep(*sweepArg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid sweep: %v\n", err)
+			os.Exit(1)
+		}
+		if swp.repeats < 1 {
+			fmt.Fprintf(os.Stderr, "Invalid sweeprepeats: %v\n", swp.repeats)
+			os.Exit(1)
+		}
+		if swp.kneePercentile <= 0 || swp.kneePercentile > 100 {
+			fmt.Fprintf(os.Stderr, "Invalid kneepercentile: %v\n", swp.kneePercentile)
+			os.Exit(1)
+		}
+		if rps != 0 {
+			fmt.Fprintf(os.Stderr, "Invalid combination of rps and sweep\n")
+			os.Exit(1)
+		}
+
+		// Always report the percentile that the knee is detected on.
+		cfg.percentiles, err = parsePercentiles(*percentilesArg, append(cfg.slo.percentiles(), swp.kneePercentile))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid percentiles: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Single rps runs and sweeps report full results, so only the hill climb stops tests early.
+	cfg.earlyAbort = *earlyAbortArg && rps == 0 && swp == nil
+
+	checkpointFile = *checkpointArg
+
+	resume = *resumeArg
+
+	cfg.seconds = *secondsArg
+
+	cfg.adaptive = *adaptiveArg
+	cfg.minSeconds = *minSecondsArg
+	if cfg.adaptive && (cfg.minSeconds < 1 || cfg.minSeconds > cfg.seconds) {
+		fmt.Fprintf(os.Stderr, "Invalid minseconds: %v\n", cfg.minSeconds)
+		os.Exit(1)
+	}
+
+	cfg.adaptiveTolerance = *adaptiveToleranceArg
+	if cfg.adaptiveTolerance <= 0 || cfg.adaptiveTolerance >= 1 {
+		fmt.Fprintf(os.Stderr, "Invalid adaptivetolerance: %v\n", cfg.adaptiveTolerance)
+		os.Exit(1)
+	}
+
+	cfg.warmupSeconds = *warmupSecondsArg
+	if cfg.warmupSeconds < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid warmupseconds: %v\n", cfg.warmupSeconds)
+		os.Exit(1)
+	}
+
+	cfg.warmupRps = *warmupRpsArg
+	if cfg.warmupRps < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid warmuprps: %v\n", cfg.warmupRps)
+		os.Exit(1)
+	}
+
+	cfg.timeout = time.Duration(*timeoutArg) * time.Millisecond
+
+	cfg.connectTimeout = time.Duration(*connectTimeoutArg) * time.Millisecond
+	if cfg.connectTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid connecttimeoutms: %v\n", *connectTimeoutArg)
+		os.Exit(1)
+	}
+
+	cfg.firstByteTimeout = time.Duration(*firstByteTimeoutArg) * time.Millisecond
+	if cfg.firstByteTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid firstbytetimeoutms: %v\n", *firstByteTimeoutArg)
+		os.Exit(1)
+	}
+
+	cfg.maxSendLag = time.Duration(*maxSendLagArg) * time.Millisecond
+	if cfg.maxSendLag < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid maxsendlagms: %v\n", *maxSendLagArg)
+		os.Exit(1)
+	}
+
+	cfg.maxConcurrent = *maxConcurrentArg
+	if cfg.maxConcurrent < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid maxconcurrent: %v\n", cfg.maxConcurrent)
+		os.Exit(1)
+	}
+
+	switch *maxConcurrentModeArg {
+	case "fail":
+		cfg.concurrencyMode = concurrencyModeFail
+	case "queue":
+		cfg.concurrencyMode = concurrencyModeQueue
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid maxconcurrentmode: %v\n", *maxConcurrentModeArg)
+		os.Exit(1)
+	}
+
+	cfg.connMaxRequests = *connMaxRequestsArg
+	if cfg.connMaxRequests < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid connmaxrequests: %v\n", cfg.connMaxRequests)
+		os.Exit(1)
+	}
+
+	cfg.connMaxAge = time.Duration(*connMaxAgeArg) * time.Millisecond
+	if cfg.connMaxAge < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid connmaxagems: %v\n", *connMaxAgeArg)
+		os.Exit(1)
+	}
+
+	cfg.connChurn = *connChurnArg
+	if cfg.connChurn < 0 || cfg.connChurn > 1 {
+		fmt.Fprintf(os.Stderr, "Invalid connchurn: %v\n", cfg.connChurn)
+		os.Exit(1)
+	}
+
+	switch *connCloseArg {
+	case "fin":
+		cfg.connCloseMode = connCloseFin
+	case "rst":
+		cfg.connCloseMode = connCloseRst
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid connclose: %v\n", *connCloseArg)
+		os.Exit(1)
+	}
+
+	cfg.retryMaxAttempts = *retryMaxAttemptsArg
+	if cfg.retryMaxAttempts < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid retrymaxattempts: %v\n", cfg.retryMaxAttempts)
+		os.Exit(1)
+	}
+
+	cfg.retryOn, err = parseRetryKinds(*retryOnArg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid retryon: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg.retryAsError = *retryAsErrorArg
+
+	cfg.histSigFigs = *histSigFigsArg
+	if cfg.histSigFigs < 1 || cfg.histSigFigs > 5 {
+		fmt.Fprintf(os.Stderr, "Invalid histsigfigs: %v\n", cfg.histSigFigs)
+		os.Exit(1)
+	}
+
+	cfg.hdrFile = *hdrFileArg
+
+	cfg.hdrLogFile = *hdrLogFileArg
+
+	cfg.timeSeriesFile = *timeSeriesArg
+
+	cfg.output = *outputArg
+	if cfg.output != "text" && cfg.output != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid output: %v\n", cfg.output)
+		os.Exit(1)
+	}
+
+	cfg.resultsFile = *resultsFileArg
+
+	cfg.requestsFile = "latencies.bin"
+	switch *requestResultsArg {
+	case "last":
+		cfg.requests = newRequestsWriter(false)
+	case "all":
+		cfg.requests = newRequestsWriter(true)
+	case "none":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid requestresults: %v\n", *requestResultsArg)
+		os.Exit(1)
+	}
+
+	if *dashboardArg && !(rps != 0 && cfg.output == "json") {
+		cfg.dashboard = newDashboard()
+	}
+
+	listenAddr = *listenArg
 
-	timeout = time.Duration(*timeoutArg) * time.Millisecond
+	outDir = *outDirArg
 
-	maxConcurrent = *maxConcurrentArg
+	cfg.probeInterval = time.Duration(*probeIntervalArg) * time.Millisecond
+	if cfg.probeInterval <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid probeintervalms: %v\n", *probeIntervalArg)
+		os.Exit(1)
+	}
+
+	cfg.recoveryFactor = *recoveryFactorArg
+	if cfg.recoveryFactor < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid recoveryfactor: %v\n", cfg.recoveryFactor)
+		os.Exit(1)
+	}
+
+	cfg.recoveryMaxWait = time.Duration(*recoveryMaxWaitArg) * time.Millisecond
+	if cfg.recoveryMaxWait < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid recoverymaxwaitms: %v\n", *recoveryMaxWaitArg)
+		os.Exit(1)
+	}
 
 	return
 }
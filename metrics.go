@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Upper bounds in milliseconds of the buckets of the latency histogram exposed on /metrics.
+var metricsLatencyBucketsMs = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+// State exposed on /metrics in the Prometheus text format. Counters cover all benchmarks of the process, so that
+// they only ever increase. The running benchmark publishes a snapshot of itself once per interval, so that serving
+// the metrics never reads the state of its workers.
+type metrics struct {
+	mu        sync.Mutex
+	finished  *stats // Sum of the stats of all benchmarks that have finished.
+	current   *stats // Stats of the running benchmark as of its latest interval, or nil if none is running.
+	inFlight  int64  // The gauges of the running benchmark as of its latest interval.
+	queued    int64
+	connsOpen int
+	warmup    bool
+	targetRps int
+	search    search // Copy of the state of the search, if any.
+	searching bool
+}
+
+var liveMetrics = &metrics{finished: newStats()}
+
+func (m *metrics) benchmarkStarted(rps int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current = newStats()
+	m.inFlight, m.queued, m.connsOpen, m.warmup = 0, 0, 0, false
+	m.targetRps = rps
+}
+
+// Publishes the state of the running benchmark. The stats must no longer change.
+func (m *metrics) intervalRecorded(s *stats, inFlight int64, queued int64, connsOpen int, warmup bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current = s
+	m.inFlight, m.queued, m.connsOpen, m.warmup = inFlight, queued, connsOpen, warmup
+}
+
+// Adds the latest snapshot of the running benchmark, which was taken once its workers had stopped, to the finished
+// ones.
+func (m *metrics) benchmarkFinished() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current != nil {
+		m.finished.add(m.current)
+	}
+	m.current = nil
+	m.inFlight, m.queued, m.connsOpen, m.warmup = 0, 0, 0, false
+}
+
+func (m *metrics) searchUpdated(s *search) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.search = *s
+	m.searching = true
+}
+
+// Serves the metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	s := newStats()
+	s.add(m.finished)
+	running, warmup := 0, 0
+	if m.current != nil {
+		s.add(m.current)
+		running = 1
+		if m.warmup {
+			warmup = 1
+		}
+	}
+	inFlight, queued, connsOpen := m.inFlight, m.queued, m.connsOpen
+	targetRps := m.targetRps
+	srch, searching := m.search, m.searching
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+
+	writeMetric(bw, "hlg_requests_started_total", "counter", "Requests whose planned time has come, including those that failed to be sent.", int64(s.reqsStarted))
+	writeMetric(bw, "hlg_requests_written_total", "counter", "Requests fully written to a connection.", int64(s.reqsWritten))
+	writeMetric(bw, "hlg_responses_received_total", "counter", "Requests that completed with a response.", int64(s.respRecvd))
+
+	writeMetricHeader(bw, "hlg_errors_total", "counter", "Failed requests by kind of error.")
+	for _, c := range s.errorBreakdown() {
+		kind := strings.TrimPrefix(c.name, "errors")
+		kind = strings.ToLower(kind[:1]) + kind[1:]
+		fmt.Fprintf(bw, "hlg_errors_total{kind=%q} %d\n", kind, c.count)
+	}
+
+	writeMetricHeader(bw, "hlg_http_responses_total", "counter", "Responses by HTTP status code.")
+	for code, n := range s.httpCodes {
+		if n != 0 {
+			fmt.Fprintf(bw, "hlg_http_responses_total{code=\"%d\"} %d\n", code, n)
+		}
+	}
+
+	writeMetric(bw, "hlg_connections_opened_total", "counter", "Connections opened to the target.", int64(s.connsOpened))
+	writeMetric(bw, "hlg_connections_closed_total", "counter", "Connections closed, by hlg or by the target.", int64(s.connsClosed))
+	writeMetric(bw, "hlg_connections_open", "gauge", "Connections currently open to the target.", int64(connsOpen))
+	writeMetric(bw, "hlg_requests_in_flight", "gauge", "Requests holding a concurrency slot.", int64(inFlight))
+	writeMetric(bw, "hlg_requests_queued", "gauge", "Requests waiting for a concurrency slot.", int64(queued))
+	writeMetric(bw, "hlg_benchmark_running", "gauge", "Whether a benchmark is running.", int64(running))
+	writeMetric(bw, "hlg_benchmark_warmup", "gauge", "Whether the running benchmark is in its warmup.", int64(warmup))
+	writeMetric(bw, "hlg_target_rps", "gauge", "Target rps of the running or latest benchmark.", int64(targetRps))
+
+	if searching {
+		writeMetric(bw, "hlg_search_iterations", "gauge", "Number of benchmarks run by the search for a sustainable rps.", int64(srch.iterations))
+		writeMetric(bw, "hlg_search_next_rps", "gauge", "The rps of the next benchmark of the search.", int64(srch.rps))
+		writeMetric(bw, "hlg_search_lower_rps", "gauge", "Highest rps known to pass the SLO, or 0 if none.", int64(srch.lower))
+		writeMetric(bw, "hlg_search_upper_rps", "gauge", "Lowest rps known to fail the SLO, or 0 if none.", int64(srch.upper))
+		converged := 0
+		if srch.converged() {
+			converged = 1
+		}
+		writeMetric(bw, "hlg_search_converged", "gauge", "Whether the search has converged.", int64(converged))
+	}
+
+	writeMetricHeader(bw, "hlg_latency_seconds", "histogram", "Latency of requests, measured from their planned time.")
+	var count int64
+	if s.hist != nil {
+		count = s.hist.totalCount
+		for _, ms := range metricsLatencyBucketsMs {
+			below := count - s.hist.countAbove(int64(ms*1000))
+			fmt.Fprintf(bw, "hlg_latency_seconds_bucket{le=\"%g\"} %d\n", ms/1000, below)
+		}
+	}
+	fmt.Fprintf(bw, "hlg_latency_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	sum := 0.0
+	if count > 0 {
+		sum = s.hist.mean() * float64(count) / 1e6
+	}
+	fmt.Fprintf(bw, "hlg_latency_seconds_sum %g\n", sum)
+	fmt.Fprintf(bw, "hlg_latency_seconds_count %d\n", count)
+
+	bw.Flush()
+}
+
+func writeMetricHeader(w *bufio.Writer, name string, typ string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeMetric(w *bufio.Writer, name string, typ string, help string, v int64) {
+	writeMetricHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
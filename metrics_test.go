@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsServeHTTP(t *testing.T) {
+	// Arrange
+	m := &metrics{finished: newStats()}
+	s := newStats()
+	s.hist = newHistogram(1, 3600000000, 3)
+	s.reqsStarted = 3
+	s.recordValue(500 * time.Microsecond)
+	s.recordValue(3 * time.Millisecond)
+	s.errorsTimeout = 1
+	s.httpCodes[200] = 2
+	m.finished.add(s)
+	m.searchUpdated(newSearch(searchBisect, 1000, 0.02, 10, 1))
+	rec := httptest.NewRecorder()
+
+	// Act
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	// Assert
+	body := rec.Body.String()
+	for _, line := range []string{
+		"hlg_requests_started_total 3\n",
+		"hlg_errors_total{kind=\"timeout\"} 1\n",
+		"hlg_http_responses_total{code=\"200\"} 2\n",
+		"hlg_search_next_rps 1000\n",
+		"hlg_benchmark_running 0\n",
+		"hlg_latency_seconds_bucket{le=\"0.001\"} 1\n",
+		"hlg_latency_seconds_bucket{le=\"0.005\"} 2\n",
+		"hlg_latency_seconds_count 2\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("Unexpected metrics, missing %q in:\n%v", line, body)
+		}
+	}
+}
+
+func TestMetricsServeHTTPRunningBenchmark(t *testing.T) {
+	// Arrange
+	m := &metrics{finished: newStats()}
+	m.benchmarkStarted(500)
+	s := newStats()
+	s.reqsStarted = 7
+	s.connsClosed = 2
+	m.intervalRecorded(s, 3, 1, 4, true)
+
+	// Act
+	running := httptest.NewRecorder()
+	m.ServeHTTP(running, httptest.NewRequest("GET", "/metrics", nil))
+	m.benchmarkFinished()
+	finished := httptest.NewRecorder()
+	m.ServeHTTP(finished, httptest.NewRequest("GET", "/metrics", nil))
+
+	// Assert
+	for _, line := range []string{
+		"hlg_requests_started_total 7\n",
+		"hlg_connections_closed_total 2\n",
+		"hlg_connections_open 4\n",
+		"hlg_requests_in_flight 3\n",
+		"hlg_requests_queued 1\n",
+		"hlg_benchmark_running 1\n",
+		"hlg_benchmark_warmup 1\n",
+		"hlg_target_rps 500\n",
+	} {
+		if !strings.Contains(running.Body.String(), line) {
+			t.Fatalf("Unexpected metrics while running, missing %q in:\n%v", line, running.Body.String())
+		}
+	}
+	for _, line := range []string{
+		"hlg_requests_started_total 7\n",
+		"hlg_connections_open 0\n",
+		"hlg_benchmark_running 0\n",
+		"hlg_target_rps 500\n",
+	} {
+		if !strings.Contains(finished.Body.String(), line) {
+			t.Fatalf("Unexpected metrics once finished, missing %q in:\n%v", line, finished.Body.String())
+		}
+	}
+}
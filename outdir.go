@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Name of the symlink in the parent of the run directories that points to the latest run.
+const latestRunLink = "latest"
+
+// Step directories are named by the number of the test in the run and its rps, such as 003-rps2250.
+var stepDirPattern = regexp.MustCompile(`^\d{3,}-rps\d+$`)
+
+// The directory of one run of hlg, which holds the files of the run as a whole, such as hillclimb.csv, and a
+// directory for each test with its per-request results, summary and time series. A nil outputDir stands for the
+// working directory, where everything is written without step directories, as before there were run directories.
+type outputDir struct {
+	root  string
+	steps int // Number of step directories made so far.
+}
+
+// Makes a directory for a new run under parent, named by its start time, and points the latest symlink to it.
+func newOutputDir(parent string, start time.Time) (o *outputDir, err error) {
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return
+	}
+
+	// Runs started within the same second get a suffix.
+	name := start.Format("20060102-150405")
+	root := filepath.Join(parent, name)
+	for i := 2; ; i++ {
+		err = os.Mkdir(root, 0755)
+		if !os.IsExist(err) {
+			break
+		}
+		root = filepath.Join(parent, fmt.Sprintf("%s-%d", name, i))
+	}
+	if err != nil {
+		return
+	}
+
+	// Replace the symlink atomically, so that it always points to a complete run directory.
+	tmp := filepath.Join(parent, latestRunLink+".tmp")
+	os.Remove(tmp)
+	err = os.Symlink(filepath.Base(root), tmp)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp, filepath.Join(parent, latestRunLink))
+	if err != nil {
+		return
+	}
+
+	o = &outputDir{root: root}
+	return
+}
+
+// Opens the latest run directory under parent, to continue a run that was interrupted. New steps are numbered after
+// the ones already there.
+func openLatestOutputDir(parent string) (o *outputDir, err error) {
+	root, err := filepath.EvalSymlinks(filepath.Join(parent, latestRunLink))
+	if err != nil {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+	o = &outputDir{root: root}
+	for _, e := range entries {
+		if e.IsDir() && stepDirPattern.MatchString(e.Name()) {
+			o.steps++
+		}
+	}
+	return
+}
+
+// The path of a file of the run as a whole. Absolute paths are kept as they are.
+func (o *outputDir) path(name string) string {
+	if o == nil || name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(o.root, name)
+}
+
+// Makes the directory for the next test of the run, and returns it with the config for the test, whose per-test files
+// are pointed into it. The time series file of the test is created as well, as each test gets its own.
+func (o *outputDir) nextStep(cfg benchmarkConfig, rps int) (stepCfg benchmarkConfig, dir string, err error) {
+	stepCfg = cfg
+	if o == nil {
+		return
+	}
+
+	o.steps++
+	dir = filepath.Join(o.root, fmt.Sprintf("%03d-rps%d", o.steps, rps))
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		return
+	}
+
+	stepFile := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return filepath.Join(dir, filepath.Base(name))
+	}
+	stepCfg.requestsFile = stepFile(cfg.requestsFile)
+	stepCfg.hdrFile = stepFile(cfg.hdrFile)
+	stepCfg.hdrLogFile = stepFile(cfg.hdrLogFile)
+	stepCfg.timeSeriesFile = stepFile(cfg.timeSeriesFile)
+	if stepCfg.timeSeriesFile != "" {
+		err = createTimeSeriesFile(stepCfg.timeSeriesFile, cfg.percentiles)
+	}
+	return
+}
+
+// Without step directories, points the histogram files of a test of a search or sweep to files numbered like step
+// directories, such as latency-003-rps2250.hgrm, so that each test does not replace the histograms of the one before.
+// The time series and per-request results of the tests share one file, and are left as they are.
+func numberStepFiles(cfg benchmarkConfig, step int, rps int) (stepCfg benchmarkConfig) {
+	stepCfg = cfg
+	numbered := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		ext := filepath.Ext(name)
+		return fmt.Sprintf("%s-%03d-rps%d%s", strings.TrimSuffix(name, ext), step, rps, ext)
+	}
+	stepCfg.hdrFile = numbered(cfg.hdrFile)
+	stepCfg.hdrLogFile = numbered(cfg.hdrLogFile)
+	return
+}
+
+// Writes the summary of a test, together with the manifest of the run, to summary.json in its step directory.
+func (o *outputDir) writeStepSummary(dir string, m runManifest, rps int, r *BenchmarkResult) (err error) {
+	if o == nil {
+		return
+	}
+
+	f, err := os.Create(filepath.Join(dir, "summary.json"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	err = writeJSONResult(f, m, rps, r)
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
+
+// Writes the manifest of the run, with its host, target and settings, to config.json.
+func (o *outputDir) writeConfig(m runManifest) (err error) {
+	if o == nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(o.path("config.json"), append(data, '\n'), 0644)
+	return
+}
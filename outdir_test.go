@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutputDirSteps(t *testing.T) {
+	// Arrange
+	parent, err := ioutil.TempDir("", "hlg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cfg := benchmarkConfig{requestsFile: "latencies.bin", timeSeriesFile: "timeseries.jsonl"}
+
+	// Act
+	first, err1 := newOutputDir(parent, start)
+	second, err2 := newOutputDir(parent, start)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Unexpected errors: %v, %v", err1, err2)
+	}
+	_, _, err1 = second.nextStep(cfg, 100)
+	stepCfg, dir, err2 := second.nextStep(cfg, 150)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Unexpected errors: %v, %v", err1, err2)
+	}
+	resumed, err := openLatestOutputDir(parent)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Assert
+	if first.root != filepath.Join(parent, "20200102-030405") || second.root != filepath.Join(parent, "20200102-030405-2") {
+		t.Fatalf("Unexpected run directories: %s, %s", first.root, second.root)
+	}
+	if dir != filepath.Join(second.root, "002-rps150") {
+		t.Fatalf("Unexpected step directory: %s", dir)
+	}
+	if stepCfg.requestsFile != filepath.Join(dir, "latencies.bin") || stepCfg.hdrFile != "" {
+		t.Fatalf("Unexpected step files: %q, %q", stepCfg.requestsFile, stepCfg.hdrFile)
+	}
+	if _, err := os.Stat(stepCfg.timeSeriesFile); err != nil {
+		t.Fatalf("Unexpected time series file: %v", err)
+	}
+	if resumed.root != second.root || resumed.steps != 2 {
+		t.Fatalf("Unexpected resumed run directory: %+v", resumed)
+	}
+	if last := lastStepDir(second.root); last != dir {
+		t.Fatalf("Unexpected last step directory: %s", last)
+	}
+	if p := second.path("hillclimb.csv"); p != filepath.Join(second.root, "hillclimb.csv") {
+		t.Fatalf("Unexpected path: %s", p)
+	}
+	var none *outputDir
+	if p := none.path("hillclimb.csv"); p != "hillclimb.csv" {
+		t.Fatalf("Unexpected path without a run directory: %s", p)
+	}
+}
+
+func TestNumberStepFiles(t *testing.T) {
+	// Arrange
+	cfg := benchmarkConfig{hdrFile: "out/latency.hgrm", hdrLogFile: "latency.hlog", requestsFile: "latencies.bin"}
+
+	// Act
+	stepCfg := numberStepFiles(cfg, 3, 2250)
+
+	// Assert
+	if stepCfg.hdrFile != "out/latency-003-rps2250.hgrm" || stepCfg.hdrLogFile != "latency-003-rps2250.hlog" {
+		t.Fatalf("Unexpected histogram files: %q, %q", stepCfg.hdrFile, stepCfg.hdrLogFile)
+	}
+	if stepCfg.requestsFile != "latencies.bin" {
+		t.Fatalf("Unexpected requestsFile: %q", stepCfg.requestsFile)
+	}
+	if numberStepFiles(benchmarkConfig{}, 1, 100).hdrFile != "" {
+		t.Fatalf("Unexpected hdrFile when not set")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"time"
+)
+
+// The phases a request goes through, each delimited by two of the timestamps recorded on the request.
+type phase int
+
+const (
+	phaseSchedLag phase = iota // From when the request was planned until hlg actually started sending it.
+	phaseConnect               // From sending until the connection was established. Zero if a keep-alive connection was reused.
+	phaseWrite                 // From the connection being established until the full request was written.
+	phaseTTFB                  // From the full request being written until the first byte of the response arrived.
+	phaseBody                  // From the first byte of the response until the full response arrived.
+	phaseCount
+)
+
+var phaseNames = [phaseCount]string{"schedLag", "connect", "write", "ttfb", "body"}
+
+type phaseResult struct {
+	count uint
+	p50   time.Duration
+	p99   time.Duration
+	p99d9 time.Duration
+	max   time.Duration
+}
+
+// Gets the duration of a phase of the request. The ok result is false if the request did not get through the phase.
+func (r *request) phaseDuration(p phase) (d time.Duration, ok bool) {
+	var from, to time.Duration
+	switch p {
+	case phaseSchedLag:
+		from, to = r.when, r.sentAt
+	case phaseConnect:
+		from, to = r.sentAt, r.connectedAt
+	case phaseWrite:
+		from, to = r.connectedAt, r.writeDoneAt
+	case phaseTTFB:
+		from, to = r.writeDoneAt, r.firstByteAt
+	case phaseBody:
+		from, to = r.firstByteAt, r.completedAt
+	}
+
+	if to == 0 || (from == 0 && p != phaseSchedLag) {
+		return
+	}
+
+	d = to - from
+	ok = true
+	return
+}
+
+// Calculates percentiles of each phase, for requests that completed and are not part of the warmup.
+func calculatePhases(reqs []request, histSigFigs int) (phases [phaseCount]phaseResult) {
+	h := newHistogram(histogramLowestMicros, histogramHighestMicros, histSigFigs)
+	for p := phase(0); p < phaseCount; p++ {
+		h.reset()
+		for i := range reqs {
+			if !reqs[i].completed || reqs[i].warmup {
+				continue
+			}
+
+			if d, ok := reqs[i].phaseDuration(p); ok {
+				h.record(int64(d / time.Microsecond))
+			}
+		}
+
+		if h.totalCount == 0 {
+			continue
+		}
+
+		phases[p] = phaseResult{
+			count: uint(h.totalCount),
+			p50:   time.Duration(h.valueAtPercentile(50)) * time.Microsecond,
+			p99:   time.Duration(h.valueAtPercentile(99)) * time.Microsecond,
+			p99d9: time.Duration(h.valueAtPercentile(99.9)) * time.Microsecond,
+			max:   time.Duration(h.max()) * time.Microsecond,
+		}
+	}
+
+	return
+}
+
+// Summarizes the send lag of a benchmark, the time from when each request was planned until a worker got to it.
+// Unlike the schedLag phase, it covers every started request and leaves out time spent waiting for a concurrency slot.
+func newSendLagResult(h *histogram) (r phaseResult) {
+	if h == nil || h.totalCount == 0 {
+		return
+	}
+
+	r = phaseResult{
+		count: uint(h.totalCount),
+		p50:   time.Duration(h.valueAtPercentile(50)) * time.Microsecond,
+		p99:   time.Duration(h.valueAtPercentile(99)) * time.Microsecond,
+		p99d9: time.Duration(h.valueAtPercentile(99.9)) * time.Microsecond,
+		max:   time.Duration(h.max()) * time.Microsecond,
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseDuration(t *testing.T) {
+	// Arrange
+	r := request{
+		when:        10 * time.Millisecond,
+		sentAt:      11 * time.Millisecond,
+		connectedAt: 13 * time.Millisecond,
+		writeDoneAt: 14 * time.Millisecond,
+		firstByteAt: 20 * time.Millisecond,
+		completedAt: 25 * time.Millisecond,
+	}
+	expected := [phaseCount]time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 1 * time.Millisecond, 6 * time.Millisecond, 5 * time.Millisecond}
+
+	for p := phase(0); p < phaseCount; p++ {
+		// Act
+		d, ok := r.phaseDuration(p)
+
+		// Assert
+		if !ok {
+			t.Fatalf("Unexpected ok for phase %s", phaseNames[p])
+		}
+		if d != expected[p] {
+			t.Fatalf("Unexpected duration for phase %s: %v", phaseNames[p], d)
+		}
+	}
+}
+
+func TestPhaseDurationIncomplete(t *testing.T) {
+	// Arrange
+	r := request{
+		when:        10 * time.Millisecond,
+		sentAt:      11 * time.Millisecond,
+		connectedAt: 13 * time.Millisecond,
+	}
+
+	// Act
+	_, okConnect := r.phaseDuration(phaseConnect)
+	_, okWrite := r.phaseDuration(phaseWrite)
+	_, okTTFB := r.phaseDuration(phaseTTFB)
+
+	// Assert
+	if !okConnect {
+		t.Fatalf("Unexpected okConnect")
+	}
+	if okWrite {
+		t.Fatalf("Unexpected okWrite")
+	}
+	if okTTFB {
+		t.Fatalf("Unexpected okTTFB")
+	}
+}
+
+func TestCalculatePhasesSkipsWarmupAndIncomplete(t *testing.T) {
+	// Arrange
+	reqs := make([]request, 0)
+	for i := 1; i <= 100; i++ {
+		reqs = append(reqs, request{completed: true, when: 0, sentAt: time.Duration(i) * time.Millisecond})
+	}
+	reqs = append(reqs, request{completed: true, warmup: true, when: 0, sentAt: time.Second})
+	reqs = append(reqs, request{completed: false, when: 0, sentAt: time.Second})
+
+	// Act
+	phases := calculatePhases(reqs, 3)
+
+	// Assert
+	if phases[phaseSchedLag].count != 100 {
+		t.Fatalf("Unexpected count: %d", phases[phaseSchedLag].count)
+	}
+	if phases[phaseSchedLag].p50 < 50*time.Millisecond || phases[phaseSchedLag].p50 > 50050*time.Microsecond {
+		t.Fatalf("Unexpected p50: %v", phases[phaseSchedLag].p50)
+	}
+	if phases[phaseSchedLag].max < 100*time.Millisecond || phases[phaseSchedLag].max > 100100*time.Microsecond {
+		t.Fatalf("Unexpected max: %v", phases[phaseSchedLag].max)
+	}
+	if phases[phaseConnect].count != 0 {
+		t.Fatalf("Unexpected connect count: %d", phases[phaseConnect].count)
+	}
+}
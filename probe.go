@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Number of consecutive probes that must look healthy before the target is considered recovered.
+const probeWindow = 5
+
+// Sends single requests at a low rate, to tell whether the target has recovered from a benchmark.
+type prober struct {
+	addr     string
+	payload  *reqPayload
+	timeout  time.Duration
+	interval time.Duration // Time between the start of consecutive probes.
+}
+
+func newProber(cfg benchmarkConfig, payload *reqPayload, interval time.Duration) *prober {
+	return &prober{
+		addr:     net.JoinHostPort(cfg.ipv4.String(), strconv.Itoa(cfg.port)),
+		payload:  payload,
+		timeout:  cfg.timeout,
+		interval: interval,
+	}
+}
+
+// Sends one request on a new connection and waits for the full response.
+func (p *prober) probe() (latency time.Duration, err error) {
+	start := time.Now()
+	deadline := start.Add(p.timeout)
+
+	conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return
+	}
+
+	_, err = conn.Write(p.payload.bytes)
+	if err != nil {
+		return
+	}
+
+	var rr ResponseReader
+	buf := make([]byte, 32*1024)
+	for {
+		var n int
+		n, err = conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		var done bool
+		done, err = rr.Read(buf[:n])
+		if err != nil {
+			return
+		}
+		if done {
+			break
+		}
+	}
+
+	// The same as a benchmark request, which fails on any code but 200.
+	if rr.ResponseCode != 200 {
+		err = fmt.Errorf("HTTP response code %d", rr.ResponseCode)
+		return
+	}
+
+	latency = time.Since(start)
+	return
+}
+
+// Sends count probes and returns the median latency. Fails if any probe fails.
+func (p *prober) baseline(count int) (median time.Duration, err error) {
+	latencies := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		var l time.Duration
+		l, err = p.probe()
+		if err != nil {
+			return
+		}
+		latencies = append(latencies, l)
+		time.Sleep(p.interval)
+	}
+
+	median = medianDuration(latencies)
+	return
+}
+
+// Probes until the last probeWindow probes all succeeded with a median latency within factor of the baseline,
+// or until maxWait has passed.
+func (p *prober) waitForRecovery(baseline time.Duration, factor float64, maxWait time.Duration) (recovered bool, waited time.Duration) {
+	start := time.Now()
+	var window []time.Duration
+	for {
+		probeStart := time.Now()
+		l, err := p.probe()
+		if err != nil {
+			window = window[:0]
+		} else {
+			window = append(window, l)
+			if len(window) > probeWindow {
+				window = window[1:]
+			}
+		}
+
+		waited = time.Since(start)
+		if len(window) == probeWindow && isRecovered(window, baseline, factor) {
+			recovered = true
+			return
+		}
+		if waited >= maxWait {
+			return
+		}
+
+		time.Sleep(p.interval - time.Since(probeStart))
+	}
+}
+
+// Whether the median of the latencies is within factor of the baseline. A millisecond of slack is allowed, so
+// that scheduling noise does not keep a target with a sub-millisecond baseline from ever counting as recovered.
+func isRecovered(latencies []time.Duration, baseline time.Duration, factor float64) bool {
+	limit := time.Duration(float64(baseline)*factor) + time.Millisecond
+	return medianDuration(latencies) <= limit
+}
+
+func medianDuration(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(ds))
+	copy(sorted, ds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMedianDuration(t *testing.T) {
+	// Arrange
+	ds := []time.Duration{5, 1, 4, 2, 3}
+
+	// Act
+	m := medianDuration(ds)
+
+	// Assert
+	if m != 3 {
+		t.Fatalf("Unexpected median: %v", m)
+	}
+	if ds[0] != 5 {
+		t.Fatalf("Unexpected reordering of input: %v", ds)
+	}
+}
+
+func TestIsRecovered(t *testing.T) {
+	// Arrange
+	baseline := 10 * time.Millisecond
+	healthy := []time.Duration{12 * time.Millisecond, 14 * time.Millisecond, 50 * time.Millisecond}
+	unhealthy := []time.Duration{30 * time.Millisecond, 14 * time.Millisecond, 50 * time.Millisecond}
+	subMillisecond := []time.Duration{900 * time.Microsecond}
+
+	// Act
+	r1 := isRecovered(healthy, baseline, 1.5)
+	r2 := isRecovered(unhealthy, baseline, 1.5)
+	r3 := isRecovered(subMillisecond, 100*time.Microsecond, 1.5)
+
+	// Assert
+	if !r1 {
+		t.Fatalf("Unexpected not recovered for %v", healthy)
+	}
+	if r2 {
+		t.Fatalf("Unexpected recovered for %v", unhealthy)
+	}
+	if !r3 {
+		t.Fatalf("Unexpected not recovered for %v", subMillisecond)
+	}
+}
+
+func TestProbeFailsOnNon200(t *testing.T) {
+	// Arrange
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 1024))
+		conn.Write([]byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"))
+	}()
+	p := &prober{addr: l.Addr().String(), payload: &reqPayload{bytes: []byte("GET / HTTP/1.1\r\n\r\n")}, timeout: time.Second}
+
+	// Act
+	_, err = p.probe()
+
+	// Assert
+	if err == nil {
+		t.Fatalf("Unexpected success of a probe answered with 404")
+	}
+}
@@ -0,0 +1,826 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One benchmark of hillclimb.csv, as needed for the report.
+type reportStep struct {
+	rps    int
+	pass   bool
+	recvd  int
+	errors int
+}
+
+// Reads the requests of a latencies.csv file, as written by earlier versions of hlg or by dump. Columns are looked up
+// by name, so files from before a column was added can still be read.
+func readRequestsCSV(r io.Reader) (reqs []requestRecord, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"whenNs", "latencyMs"} {
+		if _, ok := columns[name]; !ok {
+			err = fmt.Errorf("no %s column", name)
+			return
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	num := func(record []string, name string) int64 {
+		n, _ := strconv.ParseInt(field(record, name), 10, 64)
+		return n
+	}
+
+	for line := 2; ; line++ {
+		var record []string
+		record, err = cr.Read()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		rr := requestRecord{
+			when:        time.Duration(num(record, "whenNs")),
+			completedAt: time.Duration(num(record, "completedNs")),
+			completed:   field(record, "completed") == "1",
+			error:       field(record, "error") == "1",
+			warmup:      field(record, "warmup") == "1",
+			httpCode:    int(num(record, "httpCode")),
+			errorReason: field(record, "errorReason"),
+		}
+		if s := field(record, "latencyMs"); s != "" {
+			var ms float64
+			ms, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				err = fmt.Errorf("line %d: %v", line, err)
+				return
+			}
+			rr.responseTime = time.Duration(ms * float64(time.Millisecond))
+		}
+		reqs = append(reqs, rr)
+	}
+}
+
+// Reads the benchmarks of a hillclimb.csv file.
+func readReportSteps(r io.Reader) (steps []reportStep, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil || len(records) == 0 {
+		return
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	field := func(record []string, name string) int {
+		if i, ok := columns[name]; ok && i < len(record) {
+			n, _ := strconv.Atoi(strings.TrimSpace(record[i]))
+			return n
+		}
+		return 0
+	}
+
+	for _, record := range records[1:] {
+		steps = append(steps, reportStep{
+			rps:    field(record, "rps"),
+			pass:   field(record, "pass") == 1,
+			recvd:  field(record, "recvd"),
+			errors: field(record, "errors"),
+		})
+	}
+	return
+}
+
+// Counts per second of a timeline, from the beginning of the benchmark.
+type timeline struct {
+	started []int // Requests planned in the second.
+	recvd   []int // Responses without error received in the second.
+	errors  []int // Requests that failed in the second.
+}
+
+func (t *timeline) grow(second int) {
+	for len(t.started) <= second {
+		t.started = append(t.started, 0)
+		t.recvd = append(t.recvd, 0)
+		t.errors = append(t.errors, 0)
+	}
+}
+
+// Bins the requests into seconds. Responses and errors count in the second they completed, or the second they were
+// planned for if no completion time was recorded.
+func newTimeline(reqs []requestRecord) (t timeline) {
+	for _, r := range reqs {
+		s := int(r.when / time.Second)
+		t.grow(s)
+		t.started[s]++
+
+		if !r.completed && !r.error {
+			continue
+		}
+		if r.completedAt != 0 {
+			s = int(r.completedAt / time.Second)
+			t.grow(s)
+		}
+		if r.error {
+			t.errors[s]++
+		} else {
+			t.recvd[s]++
+		}
+	}
+	return
+}
+
+// Reads the counts per second of a time series file, as a stand-in for those of the per-request results. Each
+// interval is counted in the second it ends in, and responses with an unexpected status count as errors.
+func readTimeSeriesTimeline(r io.Reader, isJSON bool) (t timeline, err error) {
+	add := func(second float64, started uint, recvd uint, errors uint, unexpectedStatus uint) {
+		s := int(math.Ceil(second)) - 1
+		if s < 0 {
+			s = 0
+		}
+		t.grow(s)
+		t.started[s] += int(started)
+		t.recvd[s] += int(recvd - unexpectedStatus)
+		t.errors[s] += int(errors)
+	}
+
+	if isJSON {
+		dec := json.NewDecoder(r)
+		for {
+			var sample timeSeriesSample
+			err = dec.Decode(&sample)
+			if err == io.EOF {
+				err = nil
+				return
+			}
+			if err != nil {
+				return
+			}
+			var errors uint
+			for _, n := range sample.Errors {
+				errors += n
+			}
+			add(sample.Second, sample.Started, sample.Recvd, errors, sample.Errors["errorsUnexpectedHttpCode"])
+		}
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil || len(records) == 0 {
+		return
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	field := func(record []string, name string) float64 {
+		if i, ok := columns[name]; ok && i < len(record) {
+			v, _ := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+			return v
+		}
+		return 0
+	}
+	for _, record := range records[1:] {
+		add(field(record, "second"), uint(field(record, "started")), uint(field(record, "recvd")), uint(field(record, "errors")), uint(field(record, "errorsUnexpectedHttpCode")))
+	}
+	return
+}
+
+// Picks about n round tick values spanning min to max, at steps of 1, 2 or 5 times a power of ten.
+func niceTicks(min float64, max float64, n int) (ticks []float64) {
+	if max <= min || n < 1 {
+		return []float64{min}
+	}
+
+	raw := (max - min) / float64(n)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := magnitude * 10
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*magnitude {
+			step = m * magnitude
+			break
+		}
+	}
+
+	for v := math.Ceil(min/step) * step; v <= max+step*1e-9; v += step {
+		ticks = append(ticks, v)
+	}
+	return
+}
+
+// Colors of the lines and dots of the charts, cycled through if there are more lines than colors.
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+// A chart area with linear or logarithmic axes, drawn as SVG. Several charts can share one SVG document, each with
+// its own area, by opening the document with the first and drawing the axes of each.
+type svgChart struct {
+	w          io.Writer
+	width      int
+	height     int
+	left       int // Space for the y axis labels.
+	right      int // Space for the legend.
+	top        int
+	bottom     int // Space for the x axis labels.
+	xMin, xMax float64
+	yMin, yMax float64
+	logX, logY bool // Log axes span xMin to xMax in powers of ten.
+	wholeX     bool // Whether the x axis counts things, so only whole numbers get a tick.
+}
+
+func (c *svgChart) plotWidth() float64  { return float64(c.width - c.left - c.right) }
+func (c *svgChart) plotHeight() float64 { return float64(c.height - c.top - c.bottom) }
+
+func (c *svgChart) x(v float64) float64 {
+	return float64(c.left) + scaleFraction(v, c.xMin, c.xMax, c.logX)*c.plotWidth()
+}
+
+func (c *svgChart) y(v float64) float64 {
+	return float64(c.top) + (1-scaleFraction(v, c.yMin, c.yMax, c.logY))*c.plotHeight()
+}
+
+// Where a value lies between min and max, from 0 to 1. On a log scale, min and max are exponents of ten.
+func scaleFraction(v float64, min float64, max float64, log bool) float64 {
+	if log {
+		v = math.Log10(math.Max(v, math.Pow(10, min)))
+	}
+	if max <= min {
+		return 0
+	}
+	return (v - min) / (max - min)
+}
+
+// Opens the SVG element and draws the title, the grid and the axis labels.
+func (c *svgChart) begin(title string, xLabel func(float64) string, yLabel func(float64) string) {
+	c.open()
+	c.axes(title, xLabel, yLabel)
+}
+
+// Opens the SVG element, of the full width and height of the chart.
+func (c *svgChart) open() {
+	fmt.Fprintf(c.w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"12\">\n", c.width, c.height)
+	fmt.Fprintf(c.w, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+}
+
+// Draws the title, the grid and the axis labels of the chart area.
+func (c *svgChart) axes(title string, xLabel func(float64) string, yLabel func(float64) string) {
+	fmt.Fprintf(c.w, "<text x=\"%d\" y=\"%d\" font-size=\"14\">%s</text>\n", c.left, c.top-15, html.EscapeString(title))
+
+	bottom := float64(c.top) + c.plotHeight()
+	right := float64(c.left) + c.plotWidth()
+	for _, v := range c.ticks(c.xMin, c.xMax, c.logX, 8) {
+		if c.wholeX && v != math.Trunc(v) {
+			continue
+		}
+		x := c.x(v)
+		fmt.Fprintf(c.w, "<line x1=\"%.1f\" y1=\"%d\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"#ddd\"/>\n", x, c.top, x, bottom)
+		fmt.Fprintf(c.w, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\">%s</text>\n", x, bottom+15, xLabel(v))
+	}
+	for _, v := range c.ticks(c.yMin, c.yMax, c.logY, 5) {
+		y := c.y(v)
+		fmt.Fprintf(c.w, "<line x1=\"%d\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"#ddd\"/>\n", c.left, y, right, y)
+		fmt.Fprintf(c.w, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%s</text>\n", c.left-5, y+4, yLabel(v))
+	}
+}
+
+// The tick values of an axis: every power of ten on a log scale, or round values on a linear one.
+func (c *svgChart) ticks(min float64, max float64, log bool, n int) (ticks []float64) {
+	if !log {
+		return niceTicks(min, max, n)
+	}
+	for d := min; d <= max; d++ {
+		ticks = append(ticks, math.Pow(10, d))
+	}
+	return
+}
+
+// Draws an entry of the legend, at the given row to the right of the chart.
+func (c *svgChart) legend(row int, color string, name string) {
+	x := c.left + int(c.plotWidth()) + 15
+	y := c.top + 10 + row*18
+	fmt.Fprintf(c.w, "<rect x=\"%d\" y=\"%d\" width=\"20\" height=\"4\" fill=\"%s\"/>\n", x, y-2, color)
+	fmt.Fprintf(c.w, "<text x=\"%d\" y=\"%d\">%s</text>\n", x+25, y+4, html.EscapeString(name))
+}
+
+func (c *svgChart) polyline(color string, xs []float64, ys []float64) {
+	coords := make([]string, len(xs))
+	for i := range xs {
+		coords[i] = fmt.Sprintf("%.1f,%.1f", c.x(xs[i]), c.y(ys[i]))
+	}
+	fmt.Fprintf(c.w, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"2\" points=\"%s\"/>\n", color, strings.Join(coords, " "))
+}
+
+func (c *svgChart) end() {
+	fmt.Fprintf(c.w, "</svg>\n")
+}
+
+// The exponents of ten of the whole decades spanning the positive values from min to max.
+func decades(min float64, max float64) (lo float64, hi float64) {
+	lo = math.Floor(math.Log10(min))
+	hi = math.Ceil(math.Log10(max))
+	if hi == lo {
+		hi++
+	}
+	return
+}
+
+func formatMs(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64) + "ms"
+}
+
+func formatSeconds(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64) + "s"
+}
+
+func formatCount(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Size in pixels of a dot of the latency scatter plot.
+const scatterDotSize = 2
+
+// Draws the latency of every request against its planned time, on a log scale. Requests that land on the same dot
+// are drawn once, which keeps the chart small for long runs without hiding outliers. Warmup requests are grey and
+// failed requests red.
+func writeLatencyScatter(w io.Writer, reqs []requestRecord) {
+	yMin, yMax, tMax := math.Inf(1), 0.0, 0.0
+	for _, r := range reqs {
+		if r.responseTime > 0 {
+			yMin = math.Min(yMin, durationMs(r.responseTime))
+			yMax = math.Max(yMax, durationMs(r.responseTime))
+		}
+		tMax = math.Max(tMax, r.when.Seconds())
+	}
+	if yMax == 0 {
+		return
+	}
+
+	c := &svgChart{w: w, width: 900, height: 380, left: 80, right: 120, top: 40, bottom: 40, xMax: math.Max(tMax, 1)}
+	c.yMin, c.yMax = decades(yMin, yMax)
+	c.logY = true
+	c.begin("Latency by planned time of request", formatSeconds, formatMs)
+
+	type dot struct {
+		x, y  int
+		color string
+	}
+	drawn := make(map[dot]bool)
+	for _, r := range reqs {
+		if r.responseTime == 0 {
+			continue
+		}
+		color := chartColors[0]
+		if r.warmup {
+			color = "#aaa"
+		}
+		if r.error {
+			color = chartColors[3]
+		}
+		d := dot{int(c.x(r.when.Seconds())) / scatterDotSize, int(c.y(durationMs(r.responseTime))) / scatterDotSize, color}
+		if drawn[d] {
+			continue
+		}
+		drawn[d] = true
+		fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", d.x*scatterDotSize, d.y*scatterDotSize, scatterDotSize, scatterDotSize, color)
+	}
+
+	c.legend(0, chartColors[0], "measured")
+	c.legend(1, "#aaa", "warmup")
+	c.legend(2, chartColors[3], "failed")
+	c.end()
+}
+
+// Draws the latency at each percentile of the measured requests, with the percentiles spread out on a log scale of
+// 1/(1-percentile) as HdrHistogram plots do, so the tail gets as much room as the median.
+func writePercentileSpectrum(w io.Writer, d *distribution) {
+	if d.total == 0 {
+		return
+	}
+
+	// Steps of a twentieth of a nine, up to the last one the samples can resolve.
+	maxNines := math.Max(1, math.Ceil(math.Log10(float64(d.total))))
+	var xs, ys []float64
+	for k := 0.0; k <= maxNines*20; k++ {
+		nines := k / 20
+		xs = append(xs, math.Pow(10, nines))
+		ys = append(ys, d.valueAtPercentile(100*(1-math.Pow(10, -nines))))
+	}
+	xs = append(xs, math.Pow(10, maxNines))
+	ys = append(ys, d.values[len(d.values)-1])
+
+	yMin := math.Inf(1)
+	for _, v := range ys {
+		if v > 0 {
+			yMin = math.Min(yMin, v)
+		}
+	}
+	if math.IsInf(yMin, 1) {
+		return
+	}
+
+	c := &svgChart{w: w, width: 900, height: 380, left: 80, right: 120, top: 40, bottom: 40, xMax: maxNines, logX: true, logY: true}
+	c.yMin, c.yMax = decades(yMin, ys[len(ys)-1])
+	c.begin("Latency by percentile", func(v float64) string {
+		if v == 1 {
+			return "p0"
+		}
+		return percentileName(100 * (1 - 1/v))
+	}, formatMs)
+	c.polyline(chartColors[0], xs, ys)
+	c.end()
+}
+
+// Draws the requests planned, responses received and requests failed in each second.
+func writeThroughputTimeline(w io.Writer, t timeline) {
+	if len(t.started) == 0 {
+		return
+	}
+
+	yMax := 1.0
+	for s := range t.started {
+		yMax = math.Max(yMax, float64(t.started[s]))
+		yMax = math.Max(yMax, float64(t.recvd[s]))
+	}
+	ticks := niceTicks(0, yMax, 5)
+	yMax = math.Max(yMax, ticks[len(ticks)-1])
+
+	c := &svgChart{w: w, width: 900, height: 300, left: 80, right: 120, top: 40, bottom: 40, xMax: float64(len(t.started)), yMax: yMax}
+	c.begin("Throughput per second", formatSeconds, formatCount)
+	c.polyline("#999", secondsAxis(len(t.started)), countsAxis(t.started))
+	c.polyline(chartColors[0], secondsAxis(len(t.recvd)), countsAxis(t.recvd))
+	c.legend(0, "#999", "planned")
+	c.legend(1, chartColors[0], "received")
+	c.end()
+}
+
+// Draws the requests failed in each second as bars.
+func writeErrorTimeline(w io.Writer, t timeline) {
+	yMax := 0.0
+	for _, n := range t.errors {
+		yMax = math.Max(yMax, float64(n))
+	}
+	if yMax == 0 {
+		return
+	}
+	ticks := niceTicks(0, yMax, 5)
+	yMax = math.Max(yMax, ticks[len(ticks)-1])
+
+	c := &svgChart{w: w, width: 900, height: 240, left: 80, right: 120, top: 40, bottom: 40, xMax: float64(len(t.errors)), yMax: yMax}
+	c.begin("Errors per second", formatSeconds, formatCount)
+	barWidth := math.Max(1, c.plotWidth()/float64(len(t.errors))-1)
+	for s, n := range t.errors {
+		if n == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"%s\"/>\n", c.x(float64(s)), c.y(float64(n)), barWidth, c.y(0)-c.y(float64(n)), chartColors[3])
+	}
+	c.end()
+}
+
+// Draws the rps of each benchmark of the hill climb, with passing benchmarks green and failing ones red.
+func writeHillClimbTrajectory(w io.Writer, steps []reportStep) {
+	if len(steps) == 0 {
+		return
+	}
+
+	yMax := 1.0
+	for _, s := range steps {
+		yMax = math.Max(yMax, float64(s.rps))
+	}
+	ticks := niceTicks(0, yMax, 5)
+	yMax = math.Max(yMax, ticks[len(ticks)-1])
+
+	c := &svgChart{w: w, width: 900, height: 300, left: 80, right: 120, top: 40, bottom: 40, xMin: 1, xMax: math.Max(2, float64(len(steps))), yMax: yMax, wholeX: true}
+	c.begin("Hill climb", func(v float64) string { return "#" + formatCount(v) }, formatCount)
+
+	var xs, ys []float64
+	for i, s := range steps {
+		xs = append(xs, float64(i+1))
+		ys = append(ys, float64(s.rps))
+	}
+	c.polyline("#999", xs, ys)
+	for i, s := range steps {
+		color := chartColors[2]
+		if !s.pass {
+			color = chartColors[3]
+		}
+		fmt.Fprintf(w, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"4\" fill=\"%s\"><title>rps %d, %d recvd, %d errors</title></circle>\n", c.x(xs[i]), c.y(ys[i]), color, s.rps, s.recvd, s.errors)
+	}
+	c.legend(0, chartColors[2], "pass")
+	c.legend(1, chartColors[3], "fail")
+	c.end()
+}
+
+// The second of each count of a timeline, with the count drawn at the middle of its second.
+func secondsAxis(n int) (xs []float64) {
+	for s := 0; s < n; s++ {
+		xs = append(xs, float64(s)+0.5)
+	}
+	return
+}
+
+func countsAxis(counts []int) (ys []float64) {
+	for _, n := range counts {
+		ys = append(ys, float64(n))
+	}
+	return
+}
+
+// Counts how many of the measured requests got each status code or failed for each reason.
+func requestOutcomes(reqs []requestRecord) (codes map[string]int) {
+	codes = make(map[string]int)
+	for _, r := range reqs {
+		if r.warmup || (!r.completed && !r.error) {
+			continue
+		}
+		switch {
+		case r.error && r.errorReason != "" && r.errorReason != reasonNone.String():
+			codes["error: "+r.errorReason]++
+		case r.error:
+			codes["error"]++
+		default:
+			codes[strconv.Itoa(r.httpCode)]++
+		}
+	}
+	return
+}
+
+// Counts the outcomes of the measured requests of a test from its summary: the successful responses by status code,
+// and the failed requests by reason.
+func summaryOutcomes(summary *jsonResult) (codes map[string]int) {
+	codes = make(map[string]int)
+	if n := summary.HTTPCodes["200"]; n > 0 {
+		codes["200"] = int(n)
+	}
+	for reason, n := range summary.ErrorReasons {
+		codes["error: "+reason] = int(n)
+	}
+	return
+}
+
+// Writes a table of how many of the measured requests had each outcome.
+func writeOutcomeTable(w io.Writer, codes map[string]int) {
+	total := 0
+	for _, n := range codes {
+		total += n
+	}
+	if total == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(codes))
+	for name := range codes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if codes[names[i]] != codes[names[j]] {
+			return codes[names[i]] > codes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintf(w, "<h2>Outcomes</h2>\n<table>\n<tr><th>outcome</th><th>requests</th><th>share</th><th></th></tr>\n")
+	for _, name := range names {
+		share := float64(codes[name]) / float64(total)
+		color := chartColors[0]
+		if strings.HasPrefix(name, "error") {
+			color = chartColors[3]
+		}
+		fmt.Fprintf(w, "<tr><td>%s</td><td class=\"num\">%d</td><td class=\"num\">%.2f%%</td>", html.EscapeString(name), codes[name], share*100)
+		fmt.Fprintf(w, "<td><svg width=\"200\" height=\"12\"><rect width=\"%.1f\" height=\"12\" fill=\"%s\"/></svg></td></tr>\n", math.Max(1, share*200), color)
+	}
+	fmt.Fprintf(w, "</table>\n")
+}
+
+// Writes the report of the run whose output files are in dir as a single HTML document. Without per-request results,
+// the summary and time series of the last test stand in for them, if there are any, and the latency distribution is
+// left out.
+func writeReport(w io.Writer, dir string, reqs []requestRecord, summary *jsonResult, series timeline, steps []reportStep) {
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>hlg report: %s</title>\n", html.EscapeString(dir))
+	fmt.Fprintf(w, "<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}td,th{padding:2px 12px;text-align:left}td.num{text-align:right}</style>\n")
+	fmt.Fprintf(w, "</head>\n<body>\n<h1>hlg report</h1>\n")
+
+	if reqs == nil {
+		fmt.Fprintf(w, "<p>%s, generated %s. There are no per-request results, so the latency distribution is left out, and the charts show the last benchmark of the run.</p>\n", html.EscapeString(dir), time.Now().Format(time.RFC3339))
+		if summary != nil {
+			fmt.Fprintf(w, "<table>\n<tr><td>requests</td><td class=\"num\">%d</td></tr>\n<tr><td>failed</td><td class=\"num\">%d</td></tr>\n", summary.Started, summary.Errors)
+			for _, p := range summary.Percentiles {
+				fmt.Fprintf(w, "<tr><td>%s</td><td class=\"num\">%.2fms</td></tr>\n", percentileName(p.Percentile), p.Ms)
+			}
+			fmt.Fprintf(w, "<tr><td>max</td><td class=\"num\">%.2fms</td></tr>\n</table>\n", summary.MaxMs)
+		}
+		writeThroughputTimeline(w, series)
+		writeErrorTimeline(w, series)
+		if summary != nil {
+			writeOutcomeTable(w, summaryOutcomes(summary))
+		}
+		writeHillClimbTrajectory(w, steps)
+		fmt.Fprintf(w, "</body>\n</html>\n")
+		return
+	}
+
+	var measured []float64
+	requests, failed := 0, 0
+	for _, r := range reqs {
+		if r.warmup {
+			continue
+		}
+		requests++
+		if r.responseTime > 0 {
+			measured = append(measured, durationMs(r.responseTime))
+		}
+		if r.error {
+			failed++
+		}
+	}
+	d := newDistribution(measured)
+	t := newTimeline(reqs)
+
+	fmt.Fprintf(w, "<p>%s, generated %s. Per-request charts show the last benchmark of the run.</p>\n", html.EscapeString(dir), time.Now().Format(time.RFC3339))
+
+	fmt.Fprintf(w, "<table>\n<tr><td>requests</td><td class=\"num\">%d</td></tr>\n<tr><td>failed</td><td class=\"num\">%d</td></tr>\n", requests, failed)
+	for _, p := range []float64{50, 90, 99, 99.9, 99.99} {
+		fmt.Fprintf(w, "<tr><td>%s</td><td class=\"num\">%.2fms</td></tr>\n", percentileName(p), d.valueAtPercentile(p))
+	}
+	fmt.Fprintf(w, "<tr><td>max</td><td class=\"num\">%.2fms</td></tr>\n</table>\n", d.valueAtPercentile(100))
+
+	writeLatencyScatter(w, reqs)
+	writePercentileSpectrum(w, d)
+	writeThroughputTimeline(w, t)
+	writeErrorTimeline(w, t)
+	writeOutcomeTable(w, requestOutcomes(reqs))
+	writeHillClimbTrajectory(w, steps)
+
+	fmt.Fprintf(w, "</body>\n</html>\n")
+}
+
+// The step directory of the last test in a run directory made with -outdir, or empty if there is none.
+func lastStepDir(dir string) (last string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	lastStep := 0
+	for _, e := range entries {
+		if !e.IsDir() || !stepDirPattern.MatchString(e.Name()) {
+			continue
+		}
+		step, _ := strconv.Atoi(strings.SplitN(e.Name(), "-", 2)[0])
+		if step > lastStep {
+			lastStep = step
+			last = filepath.Join(dir, e.Name())
+		}
+	}
+	return
+}
+
+// Runs the report subcommand with the arguments following it, and returns the exit code.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: hlg report [flags] DIR\n\n")
+		fmt.Fprintf(fs.Output(), "Writes a self-contained HTML report with charts of the run whose latencies.bin or latencies.csv, and hillclimb.csv if it was a search, are in DIR, which can also be a run directory made with -outdir. The per-request charts show the last test. Without per-request results, the report of a run directory is drawn from the summary and time series of the last test, without the latency distribution.\n\n")
+		fs.PrintDefaults()
+	}
+	outArg := fs.String("o", "", "File to write the report to. Defaults to report.html in DIR.")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	dir := fs.Arg(0)
+	out := *outArg
+	if out == "" {
+		out = filepath.Join(dir, "report.html")
+	}
+
+	// Per-request results written before latencies.bin existed, or converted by dump, are read from latencies.csv. In
+	// a run directory made with -outdir, they are in the directory of the last test.
+	reqsDir := dir
+	if last := lastStepDir(dir); last != "" {
+		reqsDir = last
+	}
+	var reqs []requestRecord
+	name := filepath.Join(reqsDir, "latencies.bin")
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		name = filepath.Join(reqsDir, "latencies.csv")
+		f, err = os.Open(name)
+		if err == nil {
+			reqs, err = readRequestsCSV(bufio.NewReader(f))
+			f.Close()
+		}
+	} else if err == nil {
+		reqs, err = readRequestsTest(f, 0)
+		f.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	// Without them, the summary and time series of the last test stand in for them.
+	var summary *jsonResult
+	var series timeline
+	if reqs == nil {
+		var record jsonRecord
+		data, err := ioutil.ReadFile(filepath.Join(reqsDir, "summary.json"))
+		if err == nil {
+			err = json.Unmarshal(data, &record)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", filepath.Join(reqsDir, "summary.json"), err)
+				return 1
+			}
+			summary = &record.Result
+		} else if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		if name := record.Manifest.Config.TimeSeries; name != "" {
+			f, err = os.Open(filepath.Join(reqsDir, name))
+			if err == nil {
+				series, err = readTimeSeriesTimeline(bufio.NewReader(f), timeSeriesIsJSON(name))
+				f.Close()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", f.Name(), err)
+					return 1
+				}
+			} else if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+		}
+	}
+
+	var steps []reportStep
+	f, err = os.Open(filepath.Join(dir, "hillclimb.csv"))
+	if err == nil {
+		steps, err = readReportSteps(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", f.Name(), err)
+			return 1
+		}
+	} else if !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if reqs == nil && summary == nil && steps == nil {
+		fmt.Fprintf(os.Stderr, "No latencies.bin, latencies.csv, summary.json or hillclimb.csv in %s\n", dir)
+		return 1
+	}
+
+	f, err = os.Create(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	writeReport(w, dir, reqs, summary, series, steps)
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Wrote %s\n", out)
+	return 0
+}
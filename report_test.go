@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNiceTicks(t *testing.T) {
+	// Act
+	ticks := niceTicks(0, 950, 5)
+
+	// Assert
+	expected := []float64{0, 200, 400, 600, 800}
+	if len(ticks) != len(expected) {
+		t.Fatalf("Unexpected ticks: %v", ticks)
+	}
+	for i := range expected {
+		if ticks[i] != expected[i] {
+			t.Fatalf("Unexpected ticks: %v", ticks)
+		}
+	}
+}
+
+func TestReadRequestsCSV(t *testing.T) {
+	// Arrange
+	data := "whenNs,written,completed,error,httpCode,latencyMs,warmup,sentNs,connectedNs,writeDoneNs,firstByteNs,completedNs,errorReason,attempts\n" +
+		"0,1,1,0,200,1.500000,1,1,2,3,4,1500000,none,1\n" +
+		"1000000000,1,0,1,0,100.000000,0,,,,,1100000000,connectTimeout,1\n"
+
+	// Act
+	reqs, err := readRequestsCSV(strings.NewReader(data))
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("Unexpected number of requests: %d", len(reqs))
+	}
+	if !reqs[0].warmup || !reqs[0].completed || reqs[0].httpCode != 200 || reqs[0].responseTime != 1500*time.Microsecond {
+		t.Fatalf("Unexpected first request: %+v", reqs[0])
+	}
+	if !reqs[1].error || reqs[1].errorReason != "connectTimeout" || reqs[1].completedAt != 1100*time.Millisecond {
+		t.Fatalf("Unexpected second request: %+v", reqs[1])
+	}
+}
+
+func TestNewTimeline(t *testing.T) {
+	// Arrange
+	reqs := []requestRecord{
+		{when: 100 * time.Millisecond, completedAt: 200 * time.Millisecond, completed: true},
+		{when: 900 * time.Millisecond, completedAt: 1200 * time.Millisecond, completed: true},
+		{when: 1500 * time.Millisecond, error: true},
+		{when: 2500 * time.Millisecond},
+	}
+
+	// Act
+	tl := newTimeline(reqs)
+
+	// Assert
+	if len(tl.started) != 3 {
+		t.Fatalf("Unexpected number of seconds: %d", len(tl.started))
+	}
+	if tl.started[0] != 2 || tl.started[1] != 1 || tl.started[2] != 1 {
+		t.Fatalf("Unexpected started: %v", tl.started)
+	}
+	if tl.recvd[0] != 1 || tl.recvd[1] != 1 || tl.recvd[2] != 0 {
+		t.Fatalf("Unexpected recvd: %v", tl.recvd)
+	}
+	if tl.errors[1] != 1 {
+		t.Fatalf("Unexpected errors: %v", tl.errors)
+	}
+}
+
+func TestWriteReportCountsMeasuredRequests(t *testing.T) {
+	// Arrange
+	reqs := []requestRecord{
+		{when: 0, warmup: true, error: true},
+		{when: 100 * time.Millisecond, warmup: true, completed: true, responseTime: time.Millisecond},
+		{when: time.Second, completed: true, responseTime: time.Millisecond},
+		{when: 2 * time.Second, error: true, responseTime: 5 * time.Millisecond},
+		{when: 3 * time.Second, completed: true, responseTime: 2 * time.Millisecond},
+	}
+	var buf bytes.Buffer
+
+	// Act
+	writeReport(&buf, "runs/latest", reqs, nil, timeline{}, nil)
+
+	// Assert
+	body := buf.String()
+	for _, row := range []string{
+		"<tr><td>requests</td><td class=\"num\">3</td></tr>",
+		"<tr><td>failed</td><td class=\"num\">1</td></tr>",
+	} {
+		if !strings.Contains(body, row) {
+			t.Fatalf("Unexpected report, missing %q", row)
+		}
+	}
+}
+
+func TestReadTimeSeriesTimeline(t *testing.T) {
+	// Arrange
+	csv := "second,started,recvd,errors,errorsUnexpectedHttpCode,maxms\n" +
+		"1.002,100,98,3,2,5.0\n" +
+		"2.001,100,100,0,0,4.0\n" +
+		"2.500,50,40,10,0,9.0\n"
+
+	// Act
+	tl, err := readTimeSeriesTimeline(strings.NewReader(csv), false)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tl.started) != 3 {
+		t.Fatalf("Unexpected number of seconds: %d", len(tl.started))
+	}
+	if tl.started[1] != 100 || tl.started[2] != 150 {
+		t.Fatalf("Unexpected started: %v", tl.started)
+	}
+	if tl.recvd[1] != 96 || tl.recvd[2] != 140 {
+		t.Fatalf("Unexpected recvd: %v", tl.recvd)
+	}
+	if tl.errors[1] != 3 || tl.errors[2] != 10 {
+		t.Fatalf("Unexpected errors: %v", tl.errors)
+	}
+}
+
+func TestWriteReportWithoutRequests(t *testing.T) {
+	// Arrange
+	summary := &jsonResult{
+		Started:      200,
+		Errors:       4,
+		MaxMs:        12.5,
+		Percentiles:  []jsonPercentile{{Percentile: 99, Ms: 7.25}},
+		HTTPCodes:    map[string]uint{"200": 196},
+		ErrorReasons: map[string]uint{"timeout": 4},
+	}
+	series := timeline{started: []int{100, 100}, recvd: []int{98, 98}, errors: []int{2, 2}}
+	steps := []reportStep{{rps: 100, pass: true, recvd: 1000}, {rps: 200, recvd: 1900, errors: 100}}
+	var buf bytes.Buffer
+
+	// Act
+	writeReport(&buf, "runs/latest", nil, summary, series, steps)
+
+	// Assert
+	body := buf.String()
+	for _, part := range []string{
+		"<tr><td>requests</td><td class=\"num\">200</td></tr>",
+		"<tr><td>failed</td><td class=\"num\">4</td></tr>",
+		"<tr><td>p99</td><td class=\"num\">7.25ms</td></tr>",
+		"<tr><td>max</td><td class=\"num\">12.50ms</td></tr>",
+		"Throughput per second",
+		"error: timeout",
+		"Hill climb",
+	} {
+		if !strings.Contains(body, part) {
+			t.Fatalf("Unexpected report, missing %q", part)
+		}
+	}
+	if strings.Contains(body, "Latency by planned time of request") || strings.Contains(body, "Latency by percentile") {
+		t.Fatalf("Unexpected latency distribution in report without per-request results")
+	}
+}
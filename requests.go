@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// A requests file holds the per-request results of one or more tests in fixed size binary records. It starts with a
+// header naming the fields of a record and the error reasons, so that files stay readable as fields are added:
+//
+//	magic        "HLGR"
+//	version      uint8
+//	field count  uint8, then for each field its name length uint8, name and size in bytes uint8
+//	reason count uint8, then for each error reason its name length uint8 and name
+//
+// Each test follows as:
+//
+//	marker       "TEST"
+//	rps          int64
+//	start        int64, Unix time in nanoseconds
+//	count        int64, number of records
+//	records      count times the sum of the field sizes
+//
+// All numbers are little-endian, and each field is an unsigned integer of its size.
+const (
+	requestsMagic   = "HLGR"
+	requestsVersion = 1
+	requestsTest    = "TEST"
+)
+
+// One request as stored in a requests file.
+type requestRecord struct {
+	when         time.Duration // Planned time since the beginning of the benchmark.
+	sentAt       time.Duration
+	connectedAt  time.Duration
+	writeDoneAt  time.Duration
+	firstByteAt  time.Duration
+	completedAt  time.Duration
+	responseTime time.Duration // Zero if no latency was recorded.
+	httpCode     int
+	writtenDone  bool
+	completed    bool
+	error        bool
+	warmup       bool
+	errorReason  string
+	attempts     int
+}
+
+// A field of the records, with how to get it from a request and set it on a record.
+type recordField struct {
+	name string
+	size int
+	get  func(r *request) uint64
+	set  func(rec *requestRecord, v uint64, reasons []string)
+}
+
+func boolValue(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// The fields written to requests files, in order.
+var recordFields = []recordField{
+	{"whenNs", 8, func(r *request) uint64 { return uint64(r.when) }, func(rec *requestRecord, v uint64, _ []string) { rec.when = time.Duration(v) }},
+	{"sentNs", 8, func(r *request) uint64 { return uint64(r.sentAt) }, func(rec *requestRecord, v uint64, _ []string) { rec.sentAt = time.Duration(v) }},
+	{"connectedNs", 8, func(r *request) uint64 { return uint64(r.connectedAt) }, func(rec *requestRecord, v uint64, _ []string) { rec.connectedAt = time.Duration(v) }},
+	{"writeDoneNs", 8, func(r *request) uint64 { return uint64(r.writeDoneAt) }, func(rec *requestRecord, v uint64, _ []string) { rec.writeDoneAt = time.Duration(v) }},
+	{"firstByteNs", 8, func(r *request) uint64 { return uint64(r.firstByteAt) }, func(rec *requestRecord, v uint64, _ []string) { rec.firstByteAt = time.Duration(v) }},
+	{"completedNs", 8, func(r *request) uint64 { return uint64(r.completedAt) }, func(rec *requestRecord, v uint64, _ []string) { rec.completedAt = time.Duration(v) }},
+	{"latencyNs", 8, func(r *request) uint64 { return uint64(r.responseTime) }, func(rec *requestRecord, v uint64, _ []string) { rec.responseTime = time.Duration(v) }},
+	{"httpCode", 2, func(r *request) uint64 { return uint64(r.httpCode) }, func(rec *requestRecord, v uint64, _ []string) { rec.httpCode = int(v) }},
+	{"written", 1, func(r *request) uint64 { return boolValue(r.writtenDone) }, func(rec *requestRecord, v uint64, _ []string) { rec.writtenDone = v != 0 }},
+	{"completed", 1, func(r *request) uint64 { return boolValue(r.completed) }, func(rec *requestRecord, v uint64, _ []string) { rec.completed = v != 0 }},
+	{"error", 1, func(r *request) uint64 { return boolValue(r.error) }, func(rec *requestRecord, v uint64, _ []string) { rec.error = v != 0 }},
+	{"warmup", 1, func(r *request) uint64 { return boolValue(r.warmup) }, func(rec *requestRecord, v uint64, _ []string) { rec.warmup = v != 0 }},
+	{"errorReason", 1, func(r *request) uint64 { return uint64(r.errorReason) }, func(rec *requestRecord, v uint64, reasons []string) {
+		if v < uint64(len(reasons)) {
+			rec.errorReason = reasons[v]
+		}
+	}},
+	{"attempts", 1, func(r *request) uint64 {
+		if r.attempts > 255 {
+			return 255
+		}
+		return uint64(r.attempts)
+	}, func(rec *requestRecord, v uint64, _ []string) { rec.attempts = int(v) }},
+}
+
+func writeRequestsHeader(w io.Writer) (err error) {
+	var buf bytes.Buffer
+	buf.WriteString(requestsMagic)
+	buf.WriteByte(requestsVersion)
+	buf.WriteByte(byte(len(recordFields)))
+	for _, f := range recordFields {
+		buf.WriteByte(byte(len(f.name)))
+		buf.WriteString(f.name)
+		buf.WriteByte(byte(f.size))
+	}
+	buf.WriteByte(byte(reasonCount))
+	for _, name := range errorReasonNames {
+		buf.WriteByte(byte(len(name)))
+		buf.WriteString(name)
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return
+}
+
+// Writes the started requests of a test as a test section of a requests file.
+func writeRequestsTest(w io.Writer, rps int, start time.Time, reqs []request) (err error) {
+	var count int64
+	for i := range reqs {
+		if reqs[i].started {
+			count++
+		}
+	}
+
+	var head [4 + 3*8]byte
+	copy(head[:], requestsTest)
+	binary.LittleEndian.PutUint64(head[4:], uint64(rps))
+	binary.LittleEndian.PutUint64(head[12:], uint64(start.UnixNano()))
+	binary.LittleEndian.PutUint64(head[20:], uint64(count))
+	_, err = w.Write(head[:])
+	if err != nil {
+		return
+	}
+
+	var rec [8]byte
+	for i := range reqs {
+		if !reqs[i].started {
+			continue
+		}
+		for _, f := range recordFields {
+			binary.LittleEndian.PutUint64(rec[:], f.get(&reqs[i]))
+			_, err = w.Write(rec[:f.size])
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// A test of the run, queued for writing.
+type requestsJob struct {
+	filename string
+	rps      int
+	start    time.Time
+	reqs     []request
+}
+
+// Writes the per-request results of each test to a requests file on a background goroutine, so that encoding
+// millions of requests does not hold up the next test.
+type requestsWriter struct {
+	keepAll bool // Whether a test is appended to a file written earlier in the run, instead of replacing it.
+	jobs    chan requestsJob
+	done    chan struct{}
+	err     error           // The first error writing a file.
+	created map[string]bool // The files written so far.
+}
+
+func newRequestsWriter(keepAll bool) (w *requestsWriter) {
+	w = &requestsWriter{
+		keepAll: keepAll,
+		jobs:    make(chan requestsJob, 1),
+		done:    make(chan struct{}),
+		created: make(map[string]bool),
+	}
+	go w.run()
+	return
+}
+
+// Queues the requests of a test for writing to a file. Blocks while an earlier test is still queued, which bounds the
+// memory held by tests waiting to be written. The requests must no longer change.
+func (w *requestsWriter) write(filename string, rps int, start time.Time, reqs []request) {
+	w.jobs <- requestsJob{filename, rps, start, reqs}
+}
+
+// Waits for the queued tests to be written, and returns the first error, if any.
+func (w *requestsWriter) close() error {
+	close(w.jobs)
+	<-w.done
+	return w.err
+}
+
+func (w *requestsWriter) run() {
+	defer close(w.done)
+	for j := range w.jobs {
+		if w.err != nil {
+			continue
+		}
+		w.err = w.writeJob(j)
+	}
+}
+
+func (w *requestsWriter) writeJob(j requestsJob) (err error) {
+	appending := w.keepAll && w.created[j.filename]
+	name := j.filename
+	flags := os.O_WRONLY | os.O_APPEND
+	if !appending {
+		// A new file replaces the old one only once it is complete, so that stopping hlg while it is written leaves
+		// the results of the test before.
+		name = j.filename + ".tmp"
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(name, flags, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriterSize(f, 256*1024)
+	if !appending {
+		err = writeRequestsHeader(bw)
+		if err != nil {
+			return
+		}
+	}
+	err = writeRequestsTest(bw, j.rps, j.start, j.reqs)
+	if err != nil {
+		return
+	}
+	err = bw.Flush()
+	if err != nil {
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+	if !appending {
+		err = os.Rename(name, j.filename)
+		if err != nil {
+			return
+		}
+	}
+
+	w.created[j.filename] = true
+	return
+}
+
+// Whether data starts like a requests file.
+func isRequestsFile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(requestsMagic))
+}
+
+// Reads the tests and records of a requests file, in order.
+type requestsReader struct {
+	r       *bufio.Reader
+	fields  []recordField // The fields of the file. Fields this version of hlg does not know have no setter.
+	reasons []string
+	rec     []byte
+	left    int64 // Records left in the current test.
+}
+
+// A test section of a requests file.
+type requestsTestInfo struct {
+	rps   int
+	start time.Time
+	count int64
+}
+
+func newRequestsReader(r io.Reader) (rr *requestsReader, err error) {
+	rr = &requestsReader{r: bufio.NewReaderSize(r, 256*1024)}
+
+	head := make([]byte, len(requestsMagic)+2)
+	_, err = io.ReadFull(rr.r, head)
+	if err != nil {
+		return
+	}
+	if !isRequestsFile(head) {
+		err = fmt.Errorf("not a requests file")
+		return
+	}
+	if head[4] != requestsVersion {
+		err = fmt.Errorf("unsupported requests file version %d", head[4])
+		return
+	}
+
+	known := make(map[string]recordField)
+	for _, f := range recordFields {
+		known[f.name] = f
+	}
+	size := 0
+	for i := 0; i < int(head[5]); i++ {
+		var f recordField
+		f.name, err = rr.readName()
+		if err != nil {
+			return
+		}
+		var b byte
+		b, err = rr.r.ReadByte()
+		if err != nil {
+			return
+		}
+		f.size = int(b)
+		if f.size < 1 || f.size > 8 {
+			err = fmt.Errorf("invalid size %d of field %s", f.size, f.name)
+			return
+		}
+		f.set = known[f.name].set
+		rr.fields = append(rr.fields, f)
+		size += f.size
+	}
+	rr.rec = make([]byte, size)
+
+	n, err := rr.r.ReadByte()
+	if err != nil {
+		return
+	}
+	for i := 0; i < int(n); i++ {
+		var name string
+		name, err = rr.readName()
+		if err != nil {
+			return
+		}
+		rr.reasons = append(rr.reasons, name)
+	}
+	return
+}
+
+func (rr *requestsReader) readName() (name string, err error) {
+	n, err := rr.r.ReadByte()
+	if err != nil {
+		return
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(rr.r, b)
+	name = string(b)
+	return
+}
+
+// Skips what is left of the current test, and reads the header of the next one. Returns io.EOF after the last test.
+func (rr *requestsReader) nextTest() (t requestsTestInfo, err error) {
+	for ; rr.left > 0; rr.left-- {
+		_, err = io.ReadFull(rr.r, rr.rec)
+		if err != nil {
+			return
+		}
+	}
+
+	var head [4 + 3*8]byte
+	_, err = io.ReadFull(rr.r, head[:])
+	if err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("truncated test header")
+	}
+	if err != nil {
+		return
+	}
+	if string(head[:4]) != requestsTest {
+		err = fmt.Errorf("invalid test header")
+		return
+	}
+
+	t.rps = int(binary.LittleEndian.Uint64(head[4:]))
+	t.start = time.Unix(0, int64(binary.LittleEndian.Uint64(head[12:])))
+	t.count = int64(binary.LittleEndian.Uint64(head[20:]))
+	rr.left = t.count
+	return
+}
+
+// Reads the next record of the current test. Returns io.EOF after its last record.
+func (rr *requestsReader) next() (rec requestRecord, err error) {
+	if rr.left == 0 {
+		err = io.EOF
+		return
+	}
+	_, err = io.ReadFull(rr.r, rr.rec)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("truncated record")
+	}
+	if err != nil {
+		return
+	}
+	rr.left--
+
+	pos := 0
+	var v [8]byte
+	for _, f := range rr.fields {
+		if f.set != nil {
+			v = [8]byte{}
+			copy(v[:], rr.rec[pos:pos+f.size])
+			f.set(&rec, binary.LittleEndian.Uint64(v[:]), rr.reasons)
+		}
+		pos += f.size
+	}
+	return
+}
+
+// Reads the records of one test of a requests file: the one with the given rps, or the last one if rps is 0.
+func readRequestsTest(r io.Reader, rps int) (recs []requestRecord, err error) {
+	rr, err := newRequestsReader(r)
+	if err != nil {
+		return
+	}
+
+	found := false
+	for {
+		var t requestsTestInfo
+		t, err = rr.nextTest()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		if rps != 0 && t.rps != rps {
+			continue
+		}
+
+		found = true
+		recs = recs[:0]
+		for {
+			var rec requestRecord
+			rec, err = rr.next()
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			if err != nil {
+				return
+			}
+			recs = append(recs, rec)
+		}
+	}
+
+	if !found {
+		err = fmt.Errorf("no test with rps %d", rps)
+	}
+	return
+}
+
+// A record in the form written by dump as JSON lines.
+type jsonRequest struct {
+	Test        int     `json:"test"`
+	Rps         int     `json:"rps"`
+	WhenNs      int64   `json:"whenNs"`
+	Written     bool    `json:"written"`
+	Completed   bool    `json:"completed"`
+	Error       bool    `json:"error"`
+	HTTPCode    int     `json:"httpCode"`
+	LatencyMs   float64 `json:"latencyMs,omitempty"`
+	Warmup      bool    `json:"warmup"`
+	SentNs      int64   `json:"sentNs,omitempty"`
+	ConnectedNs int64   `json:"connectedNs,omitempty"`
+	WriteDoneNs int64   `json:"writeDoneNs,omitempty"`
+	FirstByteNs int64   `json:"firstByteNs,omitempty"`
+	CompletedNs int64   `json:"completedNs,omitempty"`
+	ErrorReason string  `json:"errorReason"`
+	Attempts    int     `json:"attempts"`
+}
+
+// Writes the header of the CSV form of requests, which has the columns that latencies.csv used to have, followed by
+// the test and its rps.
+func writeRequestsCSVHeader(w io.Writer) {
+	fmt.Fprintf(w, "whenNs,written,completed,error,httpCode,latencyMs,warmup,sentNs,connectedNs,writeDoneNs,firstByteNs,completedNs,errorReason,attempts,test,rps\n")
+}
+
+func writeRequestCSV(w io.Writer, test int, rps int, rec *requestRecord) {
+	fmt.Fprintf(w, "%d,%d,%d,%d,%d", rec.when, boolValue(rec.writtenDone), boolValue(rec.completed), boolValue(rec.error), rec.httpCode)
+	if rec.responseTime != 0 {
+		fmt.Fprintf(w, ",%7f", durationMs(rec.responseTime))
+	} else {
+		fmt.Fprintf(w, ",")
+	}
+	fmt.Fprintf(w, ",%d", boolValue(rec.warmup))
+	for _, t := range []time.Duration{rec.sentAt, rec.connectedAt, rec.writeDoneAt, rec.firstByteAt, rec.completedAt} {
+		if t != 0 {
+			fmt.Fprintf(w, ",%d", t)
+		} else {
+			fmt.Fprintf(w, ",")
+		}
+	}
+	fmt.Fprintf(w, ",%s,%d,%d,%d\n", rec.errorReason, rec.attempts, test, rps)
+}
+
+// Converts a requests file to CSV or JSON lines. If test is not negative, only that test is written.
+func dumpRequests(w io.Writer, r io.Reader, format string, test int) (err error) {
+	rr, err := newRequestsReader(r)
+	if err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	if format == "csv" {
+		writeRequestsCSVHeader(w)
+	}
+	for i := 0; ; i++ {
+		var t requestsTestInfo
+		t, err = rr.nextTest()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		if test >= 0 && i != test {
+			continue
+		}
+
+		for {
+			var rec requestRecord
+			rec, err = rr.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return
+			}
+
+			if format == "csv" {
+				writeRequestCSV(w, i, t.rps, &rec)
+				continue
+			}
+			err = enc.Encode(jsonRequest{
+				Test:        i,
+				Rps:         t.rps,
+				WhenNs:      int64(rec.when),
+				Written:     rec.writtenDone,
+				Completed:   rec.completed,
+				Error:       rec.error,
+				HTTPCode:    rec.httpCode,
+				LatencyMs:   durationMs(rec.responseTime),
+				Warmup:      rec.warmup,
+				SentNs:      int64(rec.sentAt),
+				ConnectedNs: int64(rec.connectedAt),
+				WriteDoneNs: int64(rec.writeDoneAt),
+				FirstByteNs: int64(rec.firstByteAt),
+				CompletedNs: int64(rec.completedAt),
+				ErrorReason: rec.errorReason,
+				Attempts:    rec.attempts,
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Runs the dump subcommand with the arguments following it, and returns the exit code.
+func runDump(args []string) int {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: hlg dump [flags] FILE\n\n")
+		fmt.Fprintf(fs.Output(), "Converts the per-request results in FILE, as written to latencies.bin, to CSV or JSON lines on stdout.\n\n")
+		fs.PrintDefaults()
+	}
+	formatArg := fs.String("format", "csv", "Either \"csv\" or \"jsonl\".")
+	testArg := fs.Int("test", -1, "Only dump this test of the file, counting from 0. -1 means all tests.")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	if *formatArg != "csv" && *formatArg != "jsonl" {
+		fmt.Fprintf(os.Stderr, "Invalid format: %v\n", *formatArg)
+		return 1
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	err = dumpRequests(w, f, *formatArg, *testArg)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	return 0
+}
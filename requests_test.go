@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestsFileRoundTrip(t *testing.T) {
+	// Arrange
+	reqs := []request{
+		{when: time.Second, sentAt: time.Second + 10, completedAt: time.Second + 2*time.Millisecond, responseTime: 2 * time.Millisecond, httpCode: 200, writtenDone: true, completed: true, attempts: 1, started: true},
+		{when: 2 * time.Second, started: false},
+		{when: 3 * time.Second, error: true, errorReason: reasonConnectTimeout, responseTime: 5 * time.Millisecond, warmup: true, attempts: 300, started: true},
+	}
+	var buf bytes.Buffer
+
+	// Act
+	err := writeRequestsHeader(&buf)
+	if err == nil {
+		err = writeRequestsTest(&buf, 100, time.Unix(0, 0), reqs[:1])
+	}
+	if err == nil {
+		err = writeRequestsTest(&buf, 200, time.Unix(0, 0), reqs)
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first, err1 := readRequestsTest(bytes.NewReader(buf.Bytes()), 100)
+	last, err2 := readRequestsTest(bytes.NewReader(buf.Bytes()), 0)
+	_, err3 := readRequestsTest(bytes.NewReader(buf.Bytes()), 300)
+
+	// Assert
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Unexpected errors: %v, %v", err1, err2)
+	}
+	if err3 == nil {
+		t.Fatalf("Unexpected success for a missing rps")
+	}
+	if len(first) != 1 || len(last) != 2 {
+		t.Fatalf("Unexpected number of records: %d, %d", len(first), len(last))
+	}
+	r := last[0]
+	if r.when != time.Second || r.sentAt != time.Second+10 || r.responseTime != 2*time.Millisecond || r.httpCode != 200 || !r.writtenDone || !r.completed || r.error || r.errorReason != "" || r.attempts != 1 {
+		t.Fatalf("Unexpected first record: %+v", r)
+	}
+	r = last[1]
+	if !r.error || !r.warmup || r.errorReason != "connectTimeout" || r.attempts != 255 {
+		t.Fatalf("Unexpected second record: %+v", r)
+	}
+}
+
+func TestDumpRequestsCSV(t *testing.T) {
+	// Arrange
+	reqs := []request{{when: time.Second, responseTime: 1500 * time.Microsecond, httpCode: 200, writtenDone: true, completed: true, attempts: 1, started: true}}
+	var in, out bytes.Buffer
+	writeRequestsHeader(&in)
+	writeRequestsTest(&in, 100, time.Unix(0, 0), reqs)
+	writeRequestsTest(&in, 200, time.Unix(0, 0), reqs)
+
+	// Act
+	err := dumpRequests(&out, &in, "csv", 1)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+	if lines[1] != "1000000000,1,1,0,200,1.500000,0,,,,,,,1,1,200" {
+		t.Fatalf("Unexpected line: %q", lines[1])
+	}
+}
+
+func TestRequestsWriterKeepsLastTest(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "hlg")
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "latencies.bin")
+	reqs := []request{{when: time.Second, httpCode: 200, completed: true, attempts: 1, started: true}}
+	w := newRequestsWriter(false)
+
+	// Act
+	w.write(filename, 100, time.Unix(0, 0), reqs)
+	w.write(filename, 200, time.Unix(0, 0), reqs)
+	err = w.close()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if _, err = os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Unexpected temporary file left: %v", err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if _, err = readRequestsTest(bytes.NewReader(data), 100); err == nil {
+		t.Fatalf("Unexpected earlier test kept")
+	}
+	if recs, err := readRequestsTest(bytes.NewReader(data), 200); err != nil || len(recs) != 1 {
+		t.Fatalf("Unexpected last test: %v, %v", recs, err)
+	}
+}
+
+func TestRequestsWriterKeepsAllTests(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "hlg")
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "latencies.bin")
+	reqs := []request{{when: time.Second, httpCode: 200, completed: true, attempts: 1, started: true}}
+	w := newRequestsWriter(true)
+
+	// Act
+	w.write(filename, 100, time.Unix(0, 0), reqs)
+	w.write(filename, 200, time.Unix(0, 0), reqs)
+	err = w.close()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	for _, rps := range []int{100, 200} {
+		if _, err = readRequestsTest(bytes.NewReader(data), rps); err != nil {
+			t.Fatalf("Unexpected err for rps %d: %v", rps, err)
+		}
+	}
+}
+
+func TestRequestsWriterWritesEachFile(t *testing.T) {
+	// Arrange
+	dir, err := ioutil.TempDir("", "hlg")
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "001-rps100.bin")
+	last := filepath.Join(dir, "002-rps200.bin")
+	reqs := []request{{when: time.Second, httpCode: 200, completed: true, attempts: 1, started: true}}
+	w := newRequestsWriter(false)
+
+	// Act
+	w.write(first, 100, time.Unix(0, 0), reqs)
+	w.write(last, 200, time.Unix(0, 0), reqs)
+	err = w.close()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	for _, filename := range []string{first, last} {
+		if _, err = os.Stat(filename); err != nil {
+			t.Fatalf("Unexpected err: %v", err)
+		}
+	}
+}
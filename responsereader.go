@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	"strconv"
 )
 
@@ -35,6 +34,16 @@ var headerKeyContentLength = []byte("Content-Length")
 
 const maxCarrySizeBytes = 1024 * 50
 
+// An error in a response that could not be parsed, with the part of the response it was in.
+type parseError struct {
+	reason errorReason
+	msg    string
+}
+
+func (e *parseError) Error() string {
+	return e.msg
+}
+
 func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 	bb := input
 	if len(r.carry) > 0 {
@@ -50,7 +59,7 @@ func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 			n := bytes.IndexByte(bb, '\n')
 			if n == -1 {
 				if len(bb) > maxCarrySizeBytes {
-					err = fmt.Errorf("response line spanning multiple packets too long")
+					err = &parseError{reasonParseStatusLine, "response line spanning multiple packets too long"}
 					return
 				}
 				r.carry = make([]byte, len(bb))
@@ -63,7 +72,7 @@ func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 			// Skip past HTTP version.
 			n = bytes.IndexByte(responseLine, ' ')
 			if n == -1 {
-				err = fmt.Errorf("invalid respose line")
+				err = &parseError{reasonParseStatusLine, "invalid respose line"}
 				return
 			}
 			responseLineRest := responseLine[n+1:]
@@ -75,7 +84,7 @@ func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 			}
 			r.ResponseCode, err = strconv.Atoi(string(responseLineRest[:n]))
 			if err != nil {
-				err = fmt.Errorf("no response code in respose line")
+				err = &parseError{reasonParseStatusLine, "no response code in respose line"}
 				return
 			}
 
@@ -85,7 +94,7 @@ func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 			n := bytes.IndexByte(bb, '\n')
 			if n == -1 {
 				if len(bb) > maxCarrySizeBytes {
-					err = fmt.Errorf("response header spanning multiple packets too long")
+					err = &parseError{reasonParseHeader, "response header spanning multiple packets too long"}
 					return
 				}
 				r.carry = make([]byte, len(bb))
@@ -109,7 +118,7 @@ func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 			// Get header name.
 			n = bytes.IndexByte(headerLine, ':')
 			if n == -1 {
-				err = fmt.Errorf("invalid header")
+				err = &parseError{reasonParseHeader, "invalid header"}
 				return
 			}
 			headerName := bytes.TrimSpace(headerLine[:n])
@@ -119,7 +128,7 @@ func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 				headerVal := string(bytes.ToLower(bytes.TrimSpace(headerLine[n+1:])))
 				r.contentLength, err = strconv.Atoi(headerVal)
 				if err != nil {
-					err = fmt.Errorf("invalid content-length header")
+					err = &parseError{reasonParseHeader, "invalid content-length header"}
 					return
 				}
 			} else if bytes.EqualFold(headerName, headerKeyTransferEncoding) {
@@ -146,7 +155,7 @@ func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 				n := bytes.IndexByte(bb, '\n')
 				if n == -1 {
 					if len(bb) > 20 {
-						err = fmt.Errorf("chunk length line too long")
+						err = &parseError{reasonParseChunk, "chunk length line too long"}
 						return
 					}
 					r.carry = make([]byte, len(bb))
@@ -166,7 +175,7 @@ func (r *ResponseReader) Read(input []byte) (done bool, err error) {
 			var l int64
 			l, err = strconv.ParseInt(string(bytes.TrimSpace(chunkLengthLine)), 16, 64)
 			if err != nil {
-				err = fmt.Errorf("invalid chunk length")
+				err = &parseError{reasonParseChunk, "invalid chunk length"}
 				return
 			}
 			r.curChunkLength = int(l)
@@ -107,6 +107,22 @@ Hi
 	}
 }
 
+func TestInvalidResponseLineParseErrorReason(t *testing.T) {
+	// Arrange
+	respBytes := forceCRLF([]byte(`HTTP/1.1200OK
+
+`))
+	r := ResponseReader{}
+
+	// Act
+	_, err := r.Read(respBytes)
+
+	// Assert
+	if pe, ok := err.(*parseError); !ok || pe.reason != reasonParseStatusLine {
+		t.Fatalf("Unexpected error %T: %v", err, err)
+	}
+}
+
 func TestInvalidResponseLine2(t *testing.T) {
 	// Arrange
 	respBytes := forceCRLF([]byte(`HTTP/1.1 200OK
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Version of hlg, set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// Describes the run a result came from: what hlg ran on, against which target, and with which settings.
+type runManifest struct {
+	Version   string     `json:"version"`
+	StartTime time.Time  `json:"startTime"`
+	Host      hostInfo   `json:"host"`
+	Target    targetInfo `json:"target"`
+	Config    jsonConfig `json:"config"`
+}
+
+type hostInfo struct {
+	Hostname  string `json:"hostname"`
+	CPUs      int    `json:"cpus"`
+	Kernel    string `json:"kernel"`
+	GoVersion string `json:"goVersion"`
+}
+
+type targetInfo struct {
+	Host string `json:"host"` // As given on the command line.
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+type jsonConfig struct {
+	Rps                int               `json:"rps"`    // The rate of a single test with -rps, or 0 for a search or sweep.
+	Search             *jsonSearchConfig `json:"search"` // Null unless the run is a search.
+	Sweep              *jsonSweepConfig  `json:"sweep"`  // Null unless the run is a sweep.
+	Seconds            int               `json:"seconds"`
+	TimeoutMs          float64           `json:"timeoutMs"`
+	ConnectTimeoutMs   float64           `json:"connectTimeoutMs"`
+	FirstByteTimeoutMs float64           `json:"firstByteTimeoutMs"`
+	MaxSendLagMs       float64           `json:"maxSendLagMs"`
+	MaxConcurrent      int               `json:"maxConcurrent"`
+	MaxConcurrentMode  string            `json:"maxConcurrentMode"`
+	WarmupSeconds      int               `json:"warmupSeconds"`
+	WarmupRps          int               `json:"warmupRps"`
+	ConnMaxRequests    int               `json:"connMaxRequests"`
+	ConnMaxAgeMs       float64           `json:"connMaxAgeMs"`
+	ConnChurn          float64           `json:"connChurn"`
+	ConnClose          string            `json:"connClose"`
+	RetryMaxAttempts   int               `json:"retryMaxAttempts"`
+	RetryOn            []string          `json:"retryOn"`
+	RetryAsError       bool              `json:"retryAsError"`
+	HistSigFigs        int               `json:"histSigFigs"`
+	Percentiles        []float64         `json:"percentiles"`
+	Slo                string            `json:"slo"`
+	EarlyAbort         bool              `json:"earlyAbort"`
+	Adaptive           bool              `json:"adaptive"`
+	MinSeconds         int               `json:"minSeconds"`
+	AdaptiveTolerance  float64           `json:"adaptiveTolerance"`
+	ProbeIntervalMs    float64           `json:"probeIntervalMs"`
+	RecoveryFactor     float64           `json:"recoveryFactor"`
+	RecoveryMaxWaitMs  float64           `json:"recoveryMaxWaitMs"`
+	TimeSeries         string            `json:"timeSeries"` // Name of the time series file of each test, or empty if none.
+}
+
+type jsonSearchConfig struct {
+	Strategy      string  `json:"strategy"`
+	StartRps      int     `json:"startRps"`
+	Tolerance     float64 `json:"tolerance"`
+	MaxIterations int     `json:"maxIterations"`
+	Repeats       int     `json:"repeats"`
+}
+
+type jsonSweepConfig struct {
+	Rps            []int   `json:"rps"`
+	Repeats        int     `json:"repeats"`
+	KneePercentile float64 `json:"kneePercentile"`
+}
+
+// A BenchmarkResult in the form written by -output json.
+type jsonResult struct {
+	Rps             int                  `json:"rps"`
+	Seconds         float64              `json:"seconds"`
+	StartedRate     float64              `json:"startedRate"`
+	RecvdRate       float64              `json:"recvdRate"`
+	ConnsOpenedRate float64              `json:"connsOpenedRate"`
+	ConnsClosedRate float64              `json:"connsClosedRate"`
+	Started         uint                 `json:"started"`
+	Recvd           uint                 `json:"recvd"`
+	Errors          uint                 `json:"errors"`
+	ErrorRate       float64              `json:"errorRate"`
+	ErrorBreakdown  map[string]uint      `json:"errorBreakdown"`
+	ErrorReasons    map[string]uint      `json:"errorReasons"` // Only reasons that were seen.
+	Retries         uint                 `json:"retries"`
+	HTTPCodes       map[string]uint      `json:"httpCodes"` // Only codes that were seen.
+	MaxMs           float64              `json:"maxMs"`
+	Percentiles     []jsonPercentile     `json:"percentiles"`
+	Spectrum        []jsonSpectrumPoint  `json:"spectrum"` // The full percentile distribution, at the same steps as HdrHistogram.
+	Phases          map[string]jsonPhase `json:"phases"`
+	SendLag         jsonPhase            `json:"sendLag"`   // How late hlg got to requests after their planned time.
+	Saturated       bool                 `json:"saturated"` // Whether hlg fell behind, making the result invalid.
+	Client          *hostLoad            `json:"client"`    // Load on the client host, or null if /proc could not be read.
+	SloPass         bool                 `json:"sloPass"`
+	SloViolations   []string             `json:"sloViolations"`
+	Aborted         bool                 `json:"aborted"`
+}
+
+type jsonPercentile struct {
+	Percentile  float64 `json:"percentile"`
+	Ms          float64 `json:"ms"`
+	TailSamples int64   `json:"tailSamples"` // Number of samples above the percentile.
+}
+
+type jsonSpectrumPoint struct {
+	Percentile float64 `json:"percentile"`
+	Ms         float64 `json:"ms"`
+	TotalCount int64   `json:"totalCount"` // Number of samples at or below the value.
+}
+
+type jsonPhase struct {
+	Count   uint    `json:"count"`
+	P50Ms   float64 `json:"p50Ms"`
+	P99Ms   float64 `json:"p99Ms"`
+	P99d9Ms float64 `json:"p99d9Ms"`
+	MaxMs   float64 `json:"maxMs"`
+}
+
+// One line of the results file, or the document written to stdout for a single benchmark.
+type jsonRecord struct {
+	Manifest runManifest `json:"manifest"`
+	Result   jsonResult  `json:"result"`
+}
+
+// Either rps is set, or swp is set for a sweep, or the run is the search srch.
+func newRunManifest(cfg benchmarkConfig, rps int, srch *search, swp *sweep, startTime time.Time) (m runManifest) {
+	m.Version = version
+	m.StartTime = startTime
+
+	m.Host.Hostname, _ = os.Hostname()
+	m.Host.CPUs = runtime.NumCPU()
+	m.Host.GoVersion = runtime.Version()
+	var uts unix.Utsname
+	if unix.Uname(&uts) == nil {
+		m.Host.Kernel = cString(uts.Sysname[:]) + " " + cString(uts.Release[:])
+	}
+
+	m.Target = targetInfo{Host: cfg.host, IP: cfg.ipv4.String(), Port: cfg.port}
+
+	mode := "fail"
+	if cfg.concurrencyMode == concurrencyModeQueue {
+		mode = "queue"
+	}
+	closeMode := "fin"
+	if cfg.connCloseMode == connCloseRst {
+		closeMode = "rst"
+	}
+	retryOn := []string{}
+	for name, kind := range retryKindNames {
+		if cfg.retryOn&kind != 0 {
+			retryOn = append(retryOn, name)
+		}
+	}
+	sort.Strings(retryOn)
+	m.Config = jsonConfig{
+		Seconds:            cfg.seconds,
+		TimeoutMs:          durationMs(cfg.timeout),
+		ConnectTimeoutMs:   durationMs(cfg.connectTimeout),
+		FirstByteTimeoutMs: durationMs(cfg.firstByteTimeout),
+		MaxSendLagMs:       durationMs(cfg.maxSendLag),
+		MaxConcurrent:      cfg.maxConcurrent,
+		MaxConcurrentMode:  mode,
+		WarmupSeconds:      cfg.warmupSeconds,
+		WarmupRps:          cfg.warmupRps,
+		ConnMaxRequests:    cfg.connMaxRequests,
+		ConnMaxAgeMs:       durationMs(cfg.connMaxAge),
+		ConnChurn:          cfg.connChurn,
+		ConnClose:          closeMode,
+		RetryMaxAttempts:   cfg.retryMaxAttempts,
+		RetryOn:            retryOn,
+		RetryAsError:       cfg.retryAsError,
+		HistSigFigs:        cfg.histSigFigs,
+		Percentiles:        cfg.percentiles,
+		Slo:                cfg.slo.String(),
+		EarlyAbort:         cfg.earlyAbort,
+		Adaptive:           cfg.adaptive,
+		MinSeconds:         cfg.minSeconds,
+		AdaptiveTolerance:  cfg.adaptiveTolerance,
+		ProbeIntervalMs:    durationMs(cfg.probeInterval),
+		RecoveryFactor:     cfg.recoveryFactor,
+		RecoveryMaxWaitMs:  durationMs(cfg.recoveryMaxWait),
+	}
+	if cfg.timeSeriesFile != "" {
+		m.Config.TimeSeries = filepath.Base(cfg.timeSeriesFile)
+	}
+
+	switch {
+	case rps != 0:
+		m.Config.Rps = rps
+	case swp != nil:
+		m.Config.Sweep = &jsonSweepConfig{Rps: swp.rpss, Repeats: swp.repeats, KneePercentile: swp.kneePercentile}
+	case srch != nil:
+		m.Config.Search = &jsonSearchConfig{
+			Strategy:      srch.strategy.String(),
+			StartRps:      srch.rps,
+			Tolerance:     srch.tolerance,
+			MaxIterations: srch.maxIterations,
+			Repeats:       srch.repeats,
+		}
+	}
+	return
+}
+
+func newJSONResult(rps int, r *BenchmarkResult) (j jsonResult) {
+	j = jsonResult{
+		Rps:             rps,
+		Seconds:         r.seconds,
+		StartedRate:     r.startedRate,
+		RecvdRate:       r.recvdRate,
+		ConnsOpenedRate: r.connsOpenedRate,
+		ConnsClosedRate: r.connsClosedRate,
+		Started:         r.started,
+		Recvd:           r.recvd,
+		Errors:          r.errors,
+		ErrorRate:       r.errorRate(),
+		ErrorBreakdown:  make(map[string]uint),
+		ErrorReasons:    make(map[string]uint),
+		HTTPCodes:       make(map[string]uint),
+		MaxMs:           durationMs(r.max),
+		Phases:          make(map[string]jsonPhase),
+		SloPass:         r.sloPass,
+		SloViolations:   append([]string{}, r.sloViolations...),
+		Aborted:         r.aborted,
+		SendLag:         newJSONPhase(r.sendLag),
+		Saturated:       r.saturated,
+		Client:          r.host,
+	}
+
+	if r.stats != nil {
+		for _, c := range r.stats.errorBreakdown() {
+			j.ErrorBreakdown[c.name] = c.count
+		}
+		j.Retries = r.stats.retries
+		for reason, n := range r.stats.errorReasons {
+			if n != 0 {
+				j.ErrorReasons[errorReason(reason).String()] = n
+			}
+		}
+		for code, n := range r.stats.httpCodes {
+			if n != 0 {
+				j.HTTPCodes[strconv.Itoa(code)] = n
+			}
+		}
+	}
+
+	for _, p := range r.percentiles {
+		j.Percentiles = append(j.Percentiles, jsonPercentile{p, durationMs(r.latencyAtPercentile(p)), r.tailSamples(p)})
+	}
+
+	if r.hist != nil {
+		for _, p := range r.hist.percentileSpectrum() {
+			j.Spectrum = append(j.Spectrum, jsonSpectrumPoint{p.percentile, float64(p.value) / 1000, p.totalCount})
+		}
+		if r.hist.totalCount > 0 {
+			j.Spectrum = append(j.Spectrum, jsonSpectrumPoint{100, durationMs(r.max), r.hist.totalCount})
+		}
+	}
+
+	for p := phase(0); p < phaseCount; p++ {
+		j.Phases[phaseNames[p]] = newJSONPhase(r.phases[p])
+	}
+
+	return
+}
+
+func newJSONPhase(p phaseResult) jsonPhase {
+	return jsonPhase{
+		Count:   p.count,
+		P50Ms:   durationMs(p.p50),
+		P99Ms:   durationMs(p.p99),
+		P99d9Ms: durationMs(p.p99d9),
+		MaxMs:   durationMs(p.max),
+	}
+}
+
+// Converts a NUL terminated byte array, as returned by uname, to a string.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Writes the result of a benchmark, together with the manifest of the run, as an indented JSON document.
+func writeJSONResult(w io.Writer, m runManifest, rps int, r *BenchmarkResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonRecord{m, newJSONResult(rps, r)})
+}
+
+// Appends the result of a benchmark, together with the manifest of the run, as a single JSON line.
+func appendJSONResult(filename string, m runManifest, rps int, r *BenchmarkResult) (err error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(jsonRecord{m, newJSONResult(rps, r)})
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
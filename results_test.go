@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWriteJSONResult(t *testing.T) {
+	// Arrange
+	s := newStats()
+	s.hist = newHistogram(1, 3600000000, 3)
+	for v := int64(1000); v <= 2000; v++ {
+		s.recordValue(time.Duration(v) * time.Microsecond)
+	}
+	s.httpCodes[200] = 1001
+	s.errorsTimeout = 3
+	r := BenchmarkResult{
+		stats:       s,
+		hist:        s.hist,
+		max:         s.max,
+		recvd:       s.respRecvd,
+		errors:      s.errors(),
+		percentiles: []float64{50, 100},
+	}
+	var buf bytes.Buffer
+
+	// Act
+	err := writeJSONResult(&buf, runManifest{Version: "test"}, 100, &r)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error %T: %v", err, err)
+	}
+	var rec jsonRecord
+	err = json.Unmarshal(buf.Bytes(), &rec)
+	if err != nil {
+		t.Fatalf("Unexpected error %T: %v", err, err)
+	}
+	if rec.Manifest.Version != "test" || rec.Result.Rps != 100 {
+		t.Fatalf("Unexpected record: %+v", rec)
+	}
+	if rec.Result.HTTPCodes["200"] != 1001 || len(rec.Result.HTTPCodes) != 1 {
+		t.Fatalf("Unexpected httpCodes: %v", rec.Result.HTTPCodes)
+	}
+	if rec.Result.ErrorBreakdown["errorsTimeout"] != 3 {
+		t.Fatalf("Unexpected errorBreakdown: %v", rec.Result.ErrorBreakdown)
+	}
+	if len(rec.Result.Percentiles) != 2 || rec.Result.Percentiles[1].Ms != 2 {
+		t.Fatalf("Unexpected percentiles: %v", rec.Result.Percentiles)
+	}
+	last := rec.Result.Spectrum[len(rec.Result.Spectrum)-1]
+	if last.Percentile != 100 || last.TotalCount != 1001 {
+		t.Fatalf("Unexpected end of spectrum: %+v", last)
+	}
+}
+
+func TestRunManifestConfig(t *testing.T) {
+	// Arrange
+	cfg := benchmarkConfig{seconds: 30, probeInterval: 100 * time.Millisecond, recoveryFactor: 1.5, timeSeriesFile: "runs/timeseries.csv"}
+	srch := newSearch(searchBisect, 1000, 0.01, 20, 3)
+	swp := &sweep{rpss: []int{100, 200}, repeats: 2, kneePercentile: 99}
+
+	// Act
+	searchConfig := newRunManifest(cfg, 0, srch, nil, time.Now()).Config
+	sweepConfig := newRunManifest(cfg, 0, srch, swp, time.Now()).Config
+	rpsConfig := newRunManifest(cfg, 500, srch, nil, time.Now()).Config
+
+	// Assert
+	if s := searchConfig.Search; s == nil || s.Strategy != "bisect" || s.StartRps != 1000 || s.Tolerance != 0.01 || s.MaxIterations != 20 || s.Repeats != 3 {
+		t.Fatalf("Unexpected search config: %+v", searchConfig.Search)
+	}
+	if searchConfig.Sweep != nil || searchConfig.ProbeIntervalMs != 100 || searchConfig.RecoveryFactor != 1.5 || searchConfig.TimeSeries != "timeseries.csv" {
+		t.Fatalf("Unexpected config: %+v", searchConfig)
+	}
+	if s := sweepConfig.Sweep; s == nil || len(s.Rps) != 2 || s.Repeats != 2 || s.KneePercentile != 99 || sweepConfig.Search != nil {
+		t.Fatalf("Unexpected sweep config: %+v", sweepConfig)
+	}
+	if rpsConfig.Rps != 500 || rpsConfig.Search != nil || rpsConfig.Sweep != nil {
+		t.Fatalf("Unexpected rps config: %+v", rpsConfig)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of failure after which a request may be retried, as a set of flags.
+type retryKinds int
+
+const (
+	retryIdle        retryKinds = 1 << iota // The server closed the connection before any of the response arrived, as when it closes a keep-alive connection it considers idle.
+	retryMidResponse                        // The server closed or reset the connection after part of the response arrived.
+)
+
+var retryKindNames = map[string]retryKinds{
+	"idle":        retryIdle,
+	"midresponse": retryMidResponse,
+}
+
+// Parses a comma separated list of retry kinds, such as "idle,midresponse". An empty string means none.
+func parseRetryKinds(s string) (kinds retryKinds, err error) {
+	if s == "" {
+		return
+	}
+
+	for _, name := range strings.Split(s, ",") {
+		kind, ok := retryKindNames[strings.TrimSpace(name)]
+		if !ok {
+			err = fmt.Errorf("unknown retry kind %q", name)
+			return
+		}
+		kinds |= kind
+	}
+
+	return
+}
+
+// Retries a request whose connection the server closed, if the retry policy allows it, and otherwise fails it for
+// the given reason. A retry keeps the original planned time of the request, so its latency includes all attempts.
+func (b *benchmarkWorker) retryOrFail(curReq *request, reason errorReason) (err error) {
+	if curReq.completed || curReq.error {
+		return
+	}
+
+	kind := retryIdle
+	if curReq.firstByteAt != 0 {
+		kind = retryMidResponse
+	}
+
+	if b.benchmark.retryOn&kind == 0 || curReq.attempts >= b.benchmark.retryMaxAttempts {
+		b.releaseSlot(curReq)
+		b.fail(curReq, reason)
+		b.statsFor(curReq).errorsNoResponse++
+		curReq.completedAt = time.Since(b.benchmark.startTime)
+		curReq.responseTime = curReq.completedAt - curReq.when
+		curReq.socketfd = 0
+		curReq.responseReader = ResponseReader{}
+		return
+	}
+
+	b.statsFor(curReq).retries++
+	err = b.reissueRequest(curReq)
+	return
+}
+
+// Counts a request that completed without error after one or more retries as an error, if retries count as errors.
+// Counting it once it completes, rather than at each retry, counts each request as at most one error.
+func (b *benchmarkWorker) countRetriedAsError(curReq *request) {
+	if b.benchmark.retryAsError && curReq.attempts > 1 && !curReq.error {
+		b.statsFor(curReq).errorsRetried++
+	}
+}
+
+// The reason a request fails with when the server closes its connection and it is not retried.
+func (r *request) closedReason() errorReason {
+	if r.firstByteAt != 0 {
+		return reasonClosedMidResponse
+	}
+	return reasonClosedIdle
+}
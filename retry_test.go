@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseRetryKinds(t *testing.T) {
+	kinds, err := parseRetryKinds("idle, midresponse")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if kinds != retryIdle|retryMidResponse {
+		t.Fatalf("Unexpected kinds: %v", kinds)
+	}
+
+	kinds, err = parseRetryKinds("")
+	if err != nil || kinds != 0 {
+		t.Fatalf("Unexpected kinds %v or error %v", kinds, err)
+	}
+
+	_, err = parseRetryKinds("idle,always")
+	if err == nil {
+		t.Fatalf("Unexpected success")
+	}
+}
+
+func TestClosedReason(t *testing.T) {
+	r := request{writeDoneAt: 1}
+	if r.closedReason() != reasonClosedIdle {
+		t.Fatalf("Unexpected reason: %v", r.closedReason())
+	}
+
+	r.firstByteAt = 2
+	if r.closedReason() != reasonClosedMidResponse {
+		t.Fatalf("Unexpected reason: %v", r.closedReason())
+	}
+}
+
+// A worker with an idle keep-alive connection registered in epoll, for a retry to be reissued on. The connection is
+// one end of a socket pair, and the other end is returned as peer.
+func newRetryTestWorker(t *testing.T, retryOn retryKinds, maxAttempts int) (w *benchmarkWorker, peer int) {
+	w = newConnTestWorker(0, 0, 0)
+	w.benchmark.payload.bytes = []byte("GET / HTTP/1.1\r\n\r\n")
+	w.benchmark.retryOn = retryOn
+	w.benchmark.retryMaxAttempts = maxAttempts
+	w.reqsInProgress = make(map[int]*request)
+	w.buf = make([]byte, 1024)
+	w.stats.hist = newHistogram(histogramLowestMicros, histogramHighestMicros, 3)
+
+	var err error
+	w.epollfd, err = unix.EpollCreate1(0)
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	err = unix.EpollCtl(w.epollfd, unix.EPOLL_CTL_ADD, fds[0], &unix.EpollEvent{Events: unix.EPOLLRDHUP, Fd: int32(fds[0])})
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	w.conns[fds[0]] = &connState{}
+	w.connRb.put(fds[0])
+	peer = fds[1]
+	return
+}
+
+func closeRetryTestWorker(w *benchmarkWorker, peer int) {
+	for fd := range w.conns {
+		unix.Close(fd)
+	}
+	unix.Close(peer)
+	unix.Close(w.epollfd)
+}
+
+func TestRetryOrFailRetriesIdleClose(t *testing.T) {
+	// Arrange
+	w, peer := newRetryTestWorker(t, retryIdle, 3)
+	defer closeRetryTestWorker(w, peer)
+	w.benchmark.startTime = time.Now().Add(-time.Second)
+	r := &request{attempts: 1, sentAt: 1, writtenDone: true, writeDoneAt: 1}
+
+	// Act
+	err := w.retryOrFail(r, reasonClosedIdle)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if r.error || r.attempts != 2 || !r.writtenDone {
+		t.Fatalf("Unexpected request: %+v", r)
+	}
+	if r.sentAt < time.Second {
+		t.Fatalf("Unexpected sentAt of the failed attempt kept: %v", r.sentAt)
+	}
+	if w.reqsInProgress[r.socketfd] != r {
+		t.Fatalf("Unexpected request not in progress on fd %d", r.socketfd)
+	}
+	if w.stats.retries != 1 || w.stats.errors() != 0 {
+		t.Fatalf("Unexpected retries %d or errors %d", w.stats.retries, w.stats.errors())
+	}
+}
+
+func TestRetryOrFailFailsMidResponseClose(t *testing.T) {
+	// Arrange
+	w, peer := newRetryTestWorker(t, retryIdle, 3)
+	defer closeRetryTestWorker(w, peer)
+	r := &request{attempts: 1, writtenDone: true, writeDoneAt: 1, firstByteAt: 2}
+
+	// Act
+	err := w.retryOrFail(r, r.closedReason())
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if !r.error || r.errorReason != reasonClosedMidResponse || r.attempts != 1 {
+		t.Fatalf("Unexpected request: %+v", r)
+	}
+	if w.stats.retries != 0 || w.stats.errorsNoResponse != 1 || w.stats.errors() != 1 {
+		t.Fatalf("Unexpected retries %d, errorsNoResponse %d or errors %d", w.stats.retries, w.stats.errorsNoResponse, w.stats.errors())
+	}
+}
+
+func TestRetryOrFailStopsAtMaxAttempts(t *testing.T) {
+	// Arrange
+	w, peer := newRetryTestWorker(t, retryIdle|retryMidResponse, 3)
+	defer closeRetryTestWorker(w, peer)
+	r := &request{attempts: 3, writtenDone: true, writeDoneAt: 1}
+
+	// Act
+	err := w.retryOrFail(r, reasonClosedIdle)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if !r.error || r.errorReason != reasonClosedIdle || r.attempts != 3 {
+		t.Fatalf("Unexpected request: %+v", r)
+	}
+	if w.stats.retries != 0 || w.stats.errors() != 1 {
+		t.Fatalf("Unexpected retries %d or errors %d", w.stats.retries, w.stats.errors())
+	}
+}
+
+func TestRetryAsErrorCountsRequestOnce(t *testing.T) {
+	// Arrange
+	w, peer := newRetryTestWorker(t, retryIdle, 5)
+	defer closeRetryTestWorker(w, peer)
+	w.benchmark.retryAsError = true
+	r := &request{attempts: 1, writtenDone: true, writeDoneAt: 1}
+
+	// Act
+	err := w.retryOrFail(r, reasonClosedIdle)
+	if err == nil {
+		// Retry again on the same connection, standing in for a new one.
+		w.reqsInProgress[r.socketfd] = nil
+		w.connRb.put(r.socketfd)
+		err = w.retryOrFail(r, reasonClosedIdle)
+	}
+	if err == nil {
+		_, err = unix.Write(peer, []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	}
+	if err == nil {
+		err = w.handleConnectionReadyToRead(r.socketfd, r)
+	}
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if !r.completed || r.error || r.attempts != 3 {
+		t.Fatalf("Unexpected request: %+v", r)
+	}
+	if w.stats.retries != 2 || w.stats.errorsRetried != 1 || w.stats.errors() != 1 {
+		t.Fatalf("Unexpected retries %d, errorsRetried %d or errors %d", w.stats.retries, w.stats.errorsRetried, w.stats.errors())
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+)
+
+// How the rps of the next benchmark is chosen when searching for the highest sustainable rps.
+type searchStrategy int
+
+const (
+	searchClimb  searchStrategy = iota // Multiplicatively increase the rps on pass, decrease it on fail, and shrink the step on every fail.
+	searchBisect                       // Double the rps until a fail, then bisect between the highest pass and lowest fail.
+	searchNoisy                        // Like bisect, but once a fail has been seen, repeat each trial and decide by majority.
+)
+
+var searchStrategyNames = map[string]searchStrategy{
+	"climb":  searchClimb,
+	"bisect": searchBisect,
+	"noisy":  searchNoisy,
+}
+
+func (s searchStrategy) String() string {
+	for name, strategy := range searchStrategyNames {
+		if strategy == s {
+			return name
+		}
+	}
+	return fmt.Sprintf("searchStrategy(%d)", int(s))
+}
+
+// Exit codes of a search that ran to the end.
+const (
+	exitCodeConverged    = 0
+	exitCodeNotConverged = 2 // Stopped at the max number of iterations before converging.
+	exitCodeNoPass       = 3 // Not even the lowest rps passed.
+	exitCodeClientLimit  = 5 // Stopped because hlg itself could not send at the rps, before finding the limit of the target.
+)
+
+// State of a search for the highest sustainable rps.
+type search struct {
+	strategy      searchStrategy
+	tolerance     float64 // Converged once the step, or the gap between the bounds, is this fraction of the rps.
+	maxIterations int     // Stop after this number of benchmarks, converged or not.
+	repeats       int     // Number of trials per rps near the boundary for the noisy strategy.
+
+	rps         int     // The rps of the next benchmark.
+	stepFactor  float64 // Relative step size of the climb strategy.
+	lower       int     // Highest rps known to pass, or 0 if none.
+	upper       int     // Lowest rps known to fail, or 0 if none.
+	iterations  int     // Number of benchmarks run so far.
+	trialRuns   int     // Number of benchmarks run so far at the current rps, for the noisy strategy.
+	trialPasses int     // Number of those that passed.
+	clientLimit int     // The rps at which hlg itself fell behind, or 0 if it has kept up.
+}
+
+func newSearch(strategy searchStrategy, startRps int, tolerance float64, maxIterations int, repeats int) *search {
+	return &search{
+		strategy:      strategy,
+		tolerance:     tolerance,
+		maxIterations: maxIterations,
+		repeats:       repeats,
+		rps:           startRps,
+		stepFactor:    0.5,
+	}
+}
+
+// Records whether the benchmark at the current rps passed, and moves on to the next rps.
+func (s *search) record(pass bool) {
+	s.iterations++
+
+	if s.strategy == searchNoisy && s.upper != 0 {
+		// Near the boundary, repeat the trial until a majority of the repeats is certain.
+		s.trialRuns++
+		if pass {
+			s.trialPasses++
+		}
+
+		needed := s.repeats/2 + 1
+		if s.trialPasses >= needed {
+			pass = true
+		} else if s.trialRuns-s.trialPasses > s.repeats-needed {
+			pass = false
+		} else {
+			return
+		}
+
+		s.trialRuns = 0
+		s.trialPasses = 0
+	}
+
+	if s.strategy == searchClimb {
+		if pass {
+			// After a fail the climb steps back below the rps that last passed, which must not lower the result.
+			if s.rps > s.lower {
+				s.lower = s.rps
+			}
+			s.rps = int(float64(s.rps) + float64(s.rps)*s.stepFactor)
+		} else {
+			s.upper = s.rps
+			s.rps = int(float64(s.rps) - float64(s.rps)*s.stepFactor)
+			s.stepFactor = s.stepFactor * 0.9
+		}
+		return
+	}
+
+	if pass {
+		s.lower = s.rps
+	} else {
+		s.upper = s.rps
+	}
+
+	switch {
+	case s.upper == 0:
+		s.rps = s.rps * 2
+	case s.lower == 0:
+		s.rps = s.rps / 2
+	default:
+		s.rps = (s.lower + s.upper) / 2
+	}
+}
+
+// Records that hlg itself could not keep up with the current rps, so that the benchmark says nothing about the target.
+// The search stops there, as higher rps would fare no better.
+func (s *search) recordClientLimit() {
+	s.iterations++
+	s.clientLimit = s.rps
+}
+
+func (s *search) converged() bool {
+	if s.strategy == searchClimb {
+		return s.lower > 0 && s.stepFactor <= s.tolerance
+	}
+
+	if s.lower == 0 || s.upper == 0 {
+		return false
+	}
+
+	gap := s.upper - s.lower
+	return gap <= 1 || float64(gap) <= float64(s.lower)*s.tolerance
+}
+
+// Whether the search should stop. The exit code tells why.
+func (s *search) done() (done bool, exitCode int) {
+	if s.converged() {
+		return true, exitCodeConverged
+	}
+
+	if s.clientLimit != 0 {
+		return true, exitCodeClientLimit
+	}
+
+	if s.rps < 1 {
+		return true, exitCodeNoPass
+	}
+
+	if s.maxIterations > 0 && s.iterations >= s.maxIterations {
+		if s.lower == 0 {
+			return true, exitCodeNoPass
+		}
+		return true, exitCodeNotConverged
+	}
+
+	return false, 0
+}
+
+// The highest rps found to be sustainable so far, or 0 if none.
+func (s *search) sustainableRps() int {
+	return s.lower
+}
+
+func (s *search) String() string {
+	return fmt.Sprintf("lower: %d, upper: %d, step: %.4f, iterations: %d", s.lower, s.upper, s.stepFactor, s.iterations)
+}
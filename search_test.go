@@ -0,0 +1,168 @@
+package main
+
+import "testing"
+
+// Runs a search against a simulated target that passes at or below capacity.
+func runSearch(s *search, pass func(rps int) bool) (exitCode int) {
+	for {
+		done, exitCode := s.done()
+		if done {
+			return exitCode
+		}
+		s.record(pass(s.rps))
+	}
+}
+
+func TestSearchClimbConverges(t *testing.T) {
+	// Arrange
+	s := newSearch(searchClimb, 1000, 0.02, 1000, 1)
+
+	// Act
+	exitCode := runSearch(s, func(rps int) bool { return rps <= 50000 })
+
+	// Assert
+	if exitCode != exitCodeConverged {
+		t.Fatalf("Unexpected exitCode: %d", exitCode)
+	}
+	if s.sustainableRps() > 50000 || s.sustainableRps() < 40000 {
+		t.Fatalf("Unexpected sustainableRps: %d", s.sustainableRps())
+	}
+}
+
+func TestSearchBisectConverges(t *testing.T) {
+	// Arrange
+	s := newSearch(searchBisect, 1000, 0.01, 100, 1)
+
+	// Act
+	exitCode := runSearch(s, func(rps int) bool { return rps <= 50000 })
+
+	// Assert
+	if exitCode != exitCodeConverged {
+		t.Fatalf("Unexpected exitCode: %d", exitCode)
+	}
+	if s.sustainableRps() > 50000 || s.sustainableRps() < 49500 {
+		t.Fatalf("Unexpected sustainableRps: %d", s.sustainableRps())
+	}
+	if s.iterations > 20 {
+		t.Fatalf("Unexpected iterations: %d", s.iterations)
+	}
+}
+
+func TestSearchBisectBelowStart(t *testing.T) {
+	// Arrange
+	s := newSearch(searchBisect, 1000, 0.01, 100, 1)
+
+	// Act
+	exitCode := runSearch(s, func(rps int) bool { return rps <= 300 })
+
+	// Assert
+	if exitCode != exitCodeConverged {
+		t.Fatalf("Unexpected exitCode: %d", exitCode)
+	}
+	if s.sustainableRps() > 300 || s.sustainableRps() < 297 {
+		t.Fatalf("Unexpected sustainableRps: %d", s.sustainableRps())
+	}
+}
+
+func TestSearchNoPass(t *testing.T) {
+	// Arrange
+	s := newSearch(searchBisect, 1000, 0.01, 100, 1)
+
+	// Act
+	exitCode := runSearch(s, func(rps int) bool { return false })
+
+	// Assert
+	if exitCode != exitCodeNoPass {
+		t.Fatalf("Unexpected exitCode: %d", exitCode)
+	}
+	if s.sustainableRps() != 0 {
+		t.Fatalf("Unexpected sustainableRps: %d", s.sustainableRps())
+	}
+}
+
+func TestSearchMaxIterations(t *testing.T) {
+	// Arrange
+	s := newSearch(searchBisect, 1000, 0.0001, 5, 1)
+
+	// Act
+	exitCode := runSearch(s, func(rps int) bool { return rps <= 50000 })
+
+	// Assert
+	if exitCode != exitCodeNotConverged {
+		t.Fatalf("Unexpected exitCode: %d", exitCode)
+	}
+	if s.iterations != 5 {
+		t.Fatalf("Unexpected iterations: %d", s.iterations)
+	}
+}
+
+func TestSearchStopsAtClientLimit(t *testing.T) {
+	// Arrange
+	s := newSearch(searchClimb, 1000, 0.02, 1000, 1)
+
+	// Act
+	exitCode := 0
+	for {
+		done, code := s.done()
+		if done {
+			exitCode = code
+			break
+		}
+		if s.rps > 20000 {
+			s.recordClientLimit()
+		} else {
+			s.record(true)
+		}
+	}
+
+	// Assert
+	if exitCode != exitCodeClientLimit {
+		t.Fatalf("Unexpected exitCode: %d", exitCode)
+	}
+	if s.clientLimit <= 20000 {
+		t.Fatalf("Unexpected clientLimit: %d", s.clientLimit)
+	}
+	if s.sustainableRps() > 20000 || s.sustainableRps() == 0 {
+		t.Fatalf("Unexpected sustainableRps: %d", s.sustainableRps())
+	}
+}
+
+func TestSearchNoisyOutvotesFlukes(t *testing.T) {
+	// Arrange
+	s := newSearch(searchNoisy, 1000, 0.01, 1000, 3)
+	runs := 0
+
+	// Act
+	// Every third trial is a fluke that gives the wrong answer.
+	exitCode := runSearch(s, func(rps int) bool {
+		runs++
+		pass := rps <= 50000
+		if runs%3 == 0 && s.upper != 0 {
+			return !pass
+		}
+		return pass
+	})
+
+	// Assert
+	if exitCode != exitCodeConverged {
+		t.Fatalf("Unexpected exitCode: %d", exitCode)
+	}
+	if s.sustainableRps() > 50000 || s.sustainableRps() < 49500 {
+		t.Fatalf("Unexpected sustainableRps: %d", s.sustainableRps())
+	}
+}
+
+func TestSearchClimbKeepsHighestPass(t *testing.T) {
+	// Arrange
+	s := newSearch(searchClimb, 1000, 0.02, 1000, 1)
+
+	// Act
+	s.record(true)  // 1000 passes, on to 1500.
+	s.record(false) // 1500 fails, back to 750.
+	s.record(true)  // 750 passes.
+
+	// Assert
+	if s.sustainableRps() != 1000 {
+		t.Fatalf("Unexpected sustainableRps: %d", s.sustainableRps())
+	}
+}
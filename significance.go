@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// A latency distribution, as distinct values in increasing order with the number of samples at each.
+type distribution struct {
+	values []float64 // Latencies in milliseconds.
+	counts []int64
+	total  int64
+}
+
+// Builds a distribution from individual samples.
+func newDistribution(samples []float64) (d *distribution) {
+	d = &distribution{}
+	sorted := append([]float64{}, samples...)
+	sort.Float64s(sorted)
+	for _, v := range sorted {
+		d.add(v, 1)
+	}
+	return
+}
+
+// Adds count samples of a value, which must not be lower than the values added before.
+func (d *distribution) add(v float64, count int64) {
+	if count <= 0 {
+		return
+	}
+
+	if n := len(d.values); n > 0 && d.values[n-1] == v {
+		d.counts[n-1] += count
+	} else {
+		d.values = append(d.values, v)
+		d.counts = append(d.counts, count)
+	}
+	d.total += count
+}
+
+// Gets the lowest value that at least the given percent of the samples are at or below, like
+// histogram.valueAtPercentile.
+func (d *distribution) valueAtPercentile(p float64) float64 {
+	if d.total == 0 {
+		return 0
+	}
+
+	countAtPercentile := int64(p/100*float64(d.total) + 0.5)
+	if countAtPercentile < 1 {
+		countAtPercentile = 1
+	}
+
+	var total int64
+	for i, c := range d.counts {
+		total += c
+		if total >= countAtPercentile {
+			return d.values[i]
+		}
+	}
+	return d.values[len(d.values)-1]
+}
+
+// Visits the distinct values of both distributions in increasing order, with the number of samples of each
+// distribution at the value.
+func mergeDistributions(a *distribution, b *distribution, visit func(v float64, countA int64, countB int64)) {
+	i, j := 0, 0
+	for i < len(a.values) || j < len(b.values) {
+		switch {
+		case j == len(b.values) || (i < len(a.values) && a.values[i] < b.values[j]):
+			visit(a.values[i], a.counts[i], 0)
+			i++
+		case i == len(a.values) || b.values[j] < a.values[i]:
+			visit(b.values[j], 0, b.counts[j])
+			j++
+		default:
+			visit(a.values[i], a.counts[i], b.counts[j])
+			i++
+			j++
+		}
+	}
+}
+
+// The outcome of a test of whether two samples come from the same distribution.
+type testResult struct {
+	statistic float64
+	p         float64 // Two-sided p-value.
+}
+
+// Runs the Mann-Whitney U test with the normal approximation and a correction for ties, which latencies rounded to
+// histogram buckets have plenty of. Also returns the probability that a sample of b exceeds a sample of a, counting
+// ties as half, which is above 0.5 when b tends to be slower.
+func mannWhitney(a *distribution, b *distribution) (r testResult, probBGreater float64) {
+	n1, n2 := float64(a.total), float64(b.total)
+	if n1 == 0 || n2 == 0 {
+		r.p = 1
+		probBGreater = 0.5
+		return
+	}
+	n := n1 + n2
+
+	// Sum the ranks of a, giving tied values the mean of the ranks they span.
+	var rankSumA, tieTerm, below float64
+	mergeDistributions(a, b, func(v float64, countA int64, countB int64) {
+		t := float64(countA + countB)
+		meanRank := below + (t+1)/2
+		rankSumA += float64(countA) * meanRank
+		tieTerm += t*t*t - t
+		below += t
+	})
+
+	uA := rankSumA - n1*(n1+1)/2 // Number of pairs where a is greater, counting ties as half.
+	r.statistic = uA
+	probBGreater = 1 - uA/(n1*n2)
+
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		r.p = 1
+		return
+	}
+	z := math.Abs(uA-mean) / math.Sqrt(variance)
+	r.p = math.Erfc(z / math.Sqrt2)
+	return
+}
+
+// Runs the two-sample Kolmogorov-Smirnov test, whose statistic is the largest distance between the cumulative
+// distributions, with the asymptotic p-value.
+func kolmogorovSmirnov(a *distribution, b *distribution) (r testResult) {
+	n1, n2 := float64(a.total), float64(b.total)
+	if n1 == 0 || n2 == 0 {
+		r.p = 1
+		return
+	}
+
+	var cumA, cumB float64
+	mergeDistributions(a, b, func(v float64, countA int64, countB int64) {
+		cumA += float64(countA)
+		cumB += float64(countB)
+		r.statistic = math.Max(r.statistic, math.Abs(cumA/n1-cumB/n2))
+	})
+
+	en := math.Sqrt(n1 * n2 / (n1 + n2))
+	r.p = kolmogorovQ((en + 0.12 + 0.11/en) * r.statistic)
+	return
+}
+
+// The complementary cumulative Kolmogorov distribution, the probability of a scaled distance of at least lambda.
+func kolmogorovQ(lambda float64) float64 {
+	if lambda < 0.2 {
+		return 1
+	}
+
+	sum := 0.0
+	sign := 1.0
+	for j := 1.0; j <= 100; j++ {
+		term := sign * 2 * math.Exp(-2*j*j*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-12 {
+			break
+		}
+		sign = -sign
+	}
+	return math.Max(0, math.Min(1, sum))
+}
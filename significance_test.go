@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistributionValueAtPercentile(t *testing.T) {
+	// Arrange
+	d := newDistribution([]float64{5, 1, 3, 3, 2, 4, 3, 3, 2, 1})
+
+	// Act & Assert
+	if d.total != 10 || len(d.values) != 5 {
+		t.Fatalf("Unexpected distribution: %+v", d)
+	}
+	if v := d.valueAtPercentile(50); v != 3 {
+		t.Fatalf("Unexpected p50: %v", v)
+	}
+	if v := d.valueAtPercentile(100); v != 5 {
+		t.Fatalf("Unexpected p100: %v", v)
+	}
+	if v := d.valueAtPercentile(0); v != 1 {
+		t.Fatalf("Unexpected p0: %v", v)
+	}
+}
+
+func TestMannWhitneySeparated(t *testing.T) {
+	// Arrange
+	a := newDistribution([]float64{1, 2, 3, 4, 5})
+	b := newDistribution([]float64{6, 7, 8, 9, 10})
+
+	// Act
+	r, probBGreater := mannWhitney(a, b)
+
+	// Assert
+	if r.statistic != 0 {
+		t.Fatalf("Unexpected U: %v", r.statistic)
+	}
+	if probBGreater != 1 {
+		t.Fatalf("Unexpected P(b > a): %v", probBGreater)
+	}
+	if r.p < 0.008 || r.p > 0.01 {
+		t.Fatalf("Unexpected p: %v", r.p)
+	}
+}
+
+func TestMannWhitneyTies(t *testing.T) {
+	// Arrange
+	a := newDistribution([]float64{1, 1, 2, 2})
+	b := newDistribution([]float64{1, 1, 2, 2})
+
+	// Act
+	r, probBGreater := mannWhitney(a, b)
+
+	// Assert
+	if r.statistic != 8 || probBGreater != 0.5 {
+		t.Fatalf("Unexpected U %v or P(b > a) %v", r.statistic, probBGreater)
+	}
+	if math.Abs(r.p-1) > 1e-9 {
+		t.Fatalf("Unexpected p: %v", r.p)
+	}
+}
+
+func TestKolmogorovSmirnov(t *testing.T) {
+	// Arrange
+	var xs, ys []float64
+	for i := 0; i < 1000; i++ {
+		xs = append(xs, float64(i))
+		ys = append(ys, float64(i)+100)
+	}
+	a, b := newDistribution(xs), newDistribution(ys)
+
+	// Act
+	same := kolmogorovSmirnov(a, a)
+	shifted := kolmogorovSmirnov(a, b)
+
+	// Assert
+	if same.statistic != 0 || same.p != 1 {
+		t.Fatalf("Unexpected result for the same distribution: %+v", same)
+	}
+	if math.Abs(shifted.statistic-0.1) > 1e-9 {
+		t.Fatalf("Unexpected D: %v", shifted.statistic)
+	}
+	if shifted.p > 0.001 {
+		t.Fatalf("Unexpected p: %v", shifted.p)
+	}
+}
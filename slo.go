@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What an SLO condition measures.
+type sloMetric int
+
+const (
+	sloMetricPercentile sloMetric = iota // Latency at a percentile.
+	sloMetricErrors                      // Number of errors.
+	sloMetricErrorRate                   // Fraction of started requests that resulted in an error.
+	sloMetricRps                         // Rate of responses received per second.
+)
+
+type sloCondition struct {
+	metric     sloMetric
+	percentile float64 // Only used for sloMetricPercentile.
+	op         string
+	threshold  float64 // Milliseconds for percentiles, a fraction for error rates, and a plain number otherwise.
+	text       string  // The condition as it was written.
+}
+
+// A service level objective, which a benchmark passes only if all of its conditions hold.
+type slo struct {
+	conditions []sloCondition
+}
+
+// Operators ordered so that two-character operators are matched before their one-character prefixes.
+var sloOps = []string{"<=", ">=", "<", ">"}
+
+// Parses a comma separated list of conditions, such as "p50<20ms,p99.9<=150ms,errorrate<0.1%,rps>=1000".
+// Percentile thresholds are durations, with a bare number meaning milliseconds. Percentiles can be written as
+// both p99.9 and p99d9, and p100 means the max. The error rate is either a fraction or a percentage.
+func parseSlo(s string) (o slo, err error) {
+	for _, text := range strings.Split(s, ",") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		var c sloCondition
+		c, err = parseSloCondition(text)
+		if err != nil {
+			return
+		}
+
+		o.conditions = append(o.conditions, c)
+	}
+
+	if len(o.conditions) == 0 {
+		err = fmt.Errorf("SLO has no conditions")
+		return
+	}
+
+	return
+}
+
+func parseSloCondition(text string) (c sloCondition, err error) {
+	c.text = text
+
+	opPos := -1
+	for _, op := range sloOps {
+		if i := strings.Index(text, op); i != -1 {
+			opPos = i
+			c.op = op
+			break
+		}
+	}
+	if opPos == -1 {
+		err = fmt.Errorf("SLO condition %q has no comparison operator", text)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(text[:opPos]))
+	val := strings.TrimSpace(text[opPos+len(c.op):])
+
+	switch {
+	case name == "errors":
+		c.metric = sloMetricErrors
+		c.threshold, err = strconv.ParseFloat(val, 64)
+
+	case name == "errorrate":
+		c.metric = sloMetricErrorRate
+		if strings.HasSuffix(val, "%") {
+			c.threshold, err = strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+			c.threshold /= 100
+		} else {
+			c.threshold, err = strconv.ParseFloat(val, 64)
+		}
+
+	case name == "rps":
+		c.metric = sloMetricRps
+		c.threshold, err = strconv.ParseFloat(val, 64)
+
+	case strings.HasPrefix(name, "p"):
+		c.metric = sloMetricPercentile
+		c.percentile, err = parsePercentile(name)
+		if err != nil {
+			return
+		}
+		c.threshold, err = parseMilliseconds(val)
+
+	default:
+		err = fmt.Errorf("SLO condition %q has unknown metric %q", text, name)
+		return
+	}
+
+	if err != nil {
+		err = fmt.Errorf("SLO condition %q has invalid threshold %q", text, val)
+		return
+	}
+
+	return
+}
+
+// Parses a percentile written as for example p99.9 or p99d9.
+func parsePercentile(s string) (p float64, err error) {
+	s = strings.TrimPrefix(strings.ToLower(s), "p")
+	s = strings.Replace(s, "d", ".", 1)
+	p, err = strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 100 {
+		err = fmt.Errorf("invalid percentile %q", s)
+		return
+	}
+
+	return
+}
+
+// Parses a duration into milliseconds. A bare number is taken to be milliseconds.
+func parseMilliseconds(s string) (ms float64, err error) {
+	ms, err = strconv.ParseFloat(s, 64)
+	if err == nil {
+		return
+	}
+
+	var d time.Duration
+	d, err = time.ParseDuration(s)
+	if err != nil {
+		return
+	}
+
+	ms = float64(d) / float64(time.Millisecond)
+	return
+}
+
+// Formats a percentile the same way as the fields in the summary, for example 99.9 becomes p99d9.
+func percentileName(p float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "d", 1)
+}
+
+// The latency percentiles that the SLO puts conditions on.
+func (o slo) percentiles() (ps []float64) {
+	for _, c := range o.conditions {
+		if c.metric == sloMetricPercentile {
+			ps = append(ps, c.percentile)
+		}
+	}
+	return
+}
+
+// Checks the result against all conditions, and describes the conditions that did not hold.
+func (o slo) evaluate(r *BenchmarkResult) (pass bool, violations []string) {
+	pass = true
+	for _, c := range o.conditions {
+		v := c.value(r)
+		if !c.holds(v) {
+			pass = false
+			violations = append(violations, fmt.Sprintf("%s (was %g)", c.text, v))
+		}
+	}
+
+	return
+}
+
+// Describes the conditions that are certain not to hold however the rest of the benchmark goes, given the stats of
+// the part that has run so far and the number of requests planned for the measured part of the benchmark.
+// Only upper bounds on latency percentiles, errors and error rate can be known to fail early.
+func (o slo) certainViolations(s *stats, planned int) (violations []string) {
+	for _, c := range o.conditions {
+		if c.op != "<" && c.op != "<=" {
+			continue
+		}
+
+		certain := false
+		switch c.metric {
+		case sloMetricPercentile:
+			// The percentile ends up above the threshold once more requests are above it than the percentile allows.
+			limit := int64(c.threshold * 1000)
+			if c.op == "<" {
+				limit--
+			}
+			allowed := int64(planned) - int64(c.percentile/100*float64(planned)+0.5)
+			certain = s.hist != nil && s.hist.countAbove(limit) > allowed
+		case sloMetricErrors:
+			certain = !c.holds(float64(s.errors()))
+		case sloMetricErrorRate:
+			// Errors can only grow, and at most the planned number of requests can be started.
+			certain = planned > 0 && !c.holds(float64(s.errors())/float64(planned))
+		}
+
+		if certain {
+			violations = append(violations, fmt.Sprintf("%s (certain after %d requests)", c.text, s.reqsStarted))
+		}
+	}
+
+	return
+}
+
+// Gets the value that the condition measures from the result, in the unit of the threshold.
+func (c sloCondition) value(r *BenchmarkResult) float64 {
+	switch c.metric {
+	case sloMetricPercentile:
+		return float64(r.latencyAtPercentile(c.percentile)) / float64(time.Millisecond)
+	case sloMetricErrors:
+		return float64(r.errors)
+	case sloMetricErrorRate:
+		return r.errorRate()
+	case sloMetricRps:
+		return r.recvdRate
+	}
+
+	return 0
+}
+
+func (c sloCondition) holds(v float64) bool {
+	switch c.op {
+	case "<":
+		return v < c.threshold
+	case "<=":
+		return v <= c.threshold
+	case ">":
+		return v > c.threshold
+	case ">=":
+		return v >= c.threshold
+	}
+
+	return false
+}
+
+func (o slo) String() string {
+	texts := make([]string, 0, len(o.conditions))
+	for _, c := range o.conditions {
+		texts = append(texts, c.text)
+	}
+	return strings.Join(texts, ",")
+}
+
+// Parses a comma separated list of percentiles such as "50,99,99.9", and merges in extra percentiles.
+// The result is sorted and without duplicates.
+func parsePercentiles(s string, extra []float64) (ps []float64, err error) {
+	seen := make(map[float64]bool)
+	add := func(p float64) {
+		if !seen[p] {
+			seen[p] = true
+			ps = append(ps, p)
+		}
+	}
+
+	for _, text := range strings.Split(s, ",") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		var p float64
+		p, err = parsePercentile(text)
+		if err != nil {
+			return
+		}
+		add(p)
+	}
+
+	for _, p := range extra {
+		add(p)
+	}
+
+	sort.Float64s(ps)
+	return
+}
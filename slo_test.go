@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSlo(t *testing.T) {
+	// Act
+	o, err := parseSlo("p50<20ms, p99d9<=0.15s,errorrate<0.1%,rps>=1000,errors<=0")
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error %T: %v", err, err)
+	}
+	if len(o.conditions) != 5 {
+		t.Fatalf("Unexpected number of conditions: %d", len(o.conditions))
+	}
+
+	c := o.conditions[0]
+	if c.metric != sloMetricPercentile || c.percentile != 50 || c.op != "<" || c.threshold != 20 {
+		t.Fatalf("Unexpected condition 0: %+v", c)
+	}
+	c = o.conditions[1]
+	if c.metric != sloMetricPercentile || c.percentile != 99.9 || c.op != "<=" || c.threshold != 150 {
+		t.Fatalf("Unexpected condition 1: %+v", c)
+	}
+	c = o.conditions[2]
+	if c.metric != sloMetricErrorRate || c.op != "<" || c.threshold != 0.001 {
+		t.Fatalf("Unexpected condition 2: %+v", c)
+	}
+	c = o.conditions[3]
+	if c.metric != sloMetricRps || c.op != ">=" || c.threshold != 1000 {
+		t.Fatalf("Unexpected condition 3: %+v", c)
+	}
+	c = o.conditions[4]
+	if c.metric != sloMetricErrors || c.op != "<=" || c.threshold != 0 {
+		t.Fatalf("Unexpected condition 4: %+v", c)
+	}
+}
+
+func TestParseSloInvalid(t *testing.T) {
+	for _, s := range []string{"", "p99", "p101<5ms", "latency<5ms", "p99<fast", "errorrate<lots"} {
+		// Act
+		_, err := parseSlo(s)
+
+		// Assert
+		if err == nil {
+			t.Fatalf("Unexpected nil error for %q", s)
+		}
+	}
+}
+
+func TestSloEvaluate(t *testing.T) {
+	// Arrange
+	o, _ := parseSlo("p50<=10ms,p100<=50ms,errorrate<1%")
+	h := newHistogram(histogramLowestMicros, histogramHighestMicros, 3)
+	for i := 0; i < 100; i++ {
+		h.record(5000)
+	}
+	r := BenchmarkResult{hist: h, max: 60 * time.Millisecond, started: 100, errors: 2}
+
+	// Act
+	pass, violations := o.evaluate(&r)
+
+	// Assert
+	if pass {
+		t.Fatalf("Unexpected pass")
+	}
+	if len(violations) != 2 {
+		t.Fatalf("Unexpected violations: %v", violations)
+	}
+	if violations[0] != "p100<=50ms (was 60)" {
+		t.Fatalf("Unexpected violation 0: %v", violations[0])
+	}
+	if violations[1] != "errorrate<1% (was 0.02)" {
+		t.Fatalf("Unexpected violation 1: %v", violations[1])
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	// Act
+	ps, err := parsePercentiles("99.9, 50,p99d99", []float64{50, 100})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error %T: %v", err, err)
+	}
+	expected := []float64{50, 99.9, 99.99, 100}
+	if len(ps) != len(expected) {
+		t.Fatalf("Unexpected percentiles: %v", ps)
+	}
+	for i := range expected {
+		if ps[i] != expected[i] {
+			t.Fatalf("Unexpected percentiles: %v", ps)
+		}
+	}
+}
+
+func TestPercentileName(t *testing.T) {
+	if percentileName(99.99) != "p99d99" {
+		t.Fatalf("Unexpected name: %v", percentileName(99.99))
+	}
+	if percentileName(50) != "p50" {
+		t.Fatalf("Unexpected name: %v", percentileName(50))
+	}
+}
+
+func TestSloCertainViolations(t *testing.T) {
+	// Arrange
+	o, err := parseSlo("p99<=100ms,errors<=5,errorrate<1%,rps>=1000")
+	if err != nil {
+		t.Fatalf("Unexpected error %T: %v", err, err)
+	}
+	s := newStats()
+	s.hist = newHistogram(1, 3600000000, 3)
+	s.reqsStarted = 100
+	s.hist.recordCount(10000, 90)
+	s.hist.recordCount(200000, 10)
+	s.errorsTimeout = 5
+
+	// Act
+	fewPlanned := o.certainViolations(s, 500)
+	manyPlanned := o.certainViolations(s, 10000)
+
+	// Assert
+	if len(fewPlanned) != 2 || !strings.HasPrefix(fewPlanned[0], "p99<=100ms") || !strings.HasPrefix(fewPlanned[1], "errorrate<1%") {
+		t.Fatalf("Unexpected violations: %v", fewPlanned)
+	}
+	if len(manyPlanned) != 0 {
+		t.Fatalf("Unexpected violations: %v", manyPlanned)
+	}
+}
+
+func TestLatencyAtPercentileAtMostMax(t *testing.T) {
+	// Arrange
+	h := newHistogram(histogramLowestMicros, histogramHighestMicros, 2)
+	h.record(123456)
+	r := BenchmarkResult{hist: h, max: 123456 * time.Microsecond}
+
+	// Act
+	p99 := r.latencyAtPercentile(99)
+
+	// Assert
+	if h.valueAtPercentile(99) <= 123456 {
+		t.Fatalf("Unexpected histogram value: %d", h.valueAtPercentile(99))
+	}
+	if p99 != r.max {
+		t.Fatalf("Unexpected p99: %v", p99)
+	}
+}
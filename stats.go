@@ -11,14 +11,23 @@ type stats struct {
 	errorsTooManyConcurrent  uint
 	errorsResponseReader     uint
 	errorsNoResponse         uint
-	errorsTimeout            uint
+	errorsTimeout            uint // Requests that ran past the total timeout.
+	errorsConnectTimeout     uint
+	errorsFirstByteTimeout   uint
 	errorsSocketCreate       uint
 	errorsSocketSetSockOpt   uint
 	errorsSocketConnect      uint
 	errorsSocketWrite        uint
 	errorsUnexpectedHttpCode uint
+	errorsRetried            uint              // Requests that succeeded after retries, when those are to be counted as errors.
+	retries                  uint              // Requests reissued after the server closed their connection.
+	errorReasons             [reasonCount]uint // Failed requests by specific reason.
 	httpCodes                [1000]uint
+	connsOpened              uint
+	connsClosed              uint
 	max                      time.Duration
+	hist                     *histogram // Latencies in microseconds. Nil if latencies are not to be recorded for these stats.
+	sendLag                  *histogram // How late in microseconds the worker got to each request after its planned time. Nil if not recorded.
 }
 
 func newStats() (s *stats) {
@@ -27,9 +36,154 @@ func newStats() (s *stats) {
 }
 
 func (s *stats) recordValue(d time.Duration) {
+	s.respRecvd++
+	s.recordLatency(d)
+}
+
+// Records the latency of a request in the histogram, without counting it as a received response, such as that of a
+// request that timed out.
+func (s *stats) recordLatency(d time.Duration) {
 	if d > s.max {
 		s.max = d
 	}
+	if s.hist != nil {
+		s.hist.record(int64(d / time.Microsecond))
+	}
+}
 
-	s.respRecvd++
+// A named counter, for reporting.
+type namedCount struct {
+	name  string
+	count uint
+}
+
+// The error counters by name, in the order they are reported.
+func (s *stats) errorBreakdown() []namedCount {
+	return []namedCount{
+		{"errorsTooManyConcurrent", s.errorsTooManyConcurrent},
+		{"errorsResponseReader", s.errorsResponseReader},
+		{"errorsNoResponse", s.errorsNoResponse},
+		{"errorsTimeout", s.errorsTimeout},
+		{"errorsConnectTimeout", s.errorsConnectTimeout},
+		{"errorsFirstByteTimeout", s.errorsFirstByteTimeout},
+		{"errorsSocketCreate", s.errorsSocketCreate},
+		{"errorsSocketConnect", s.errorsSocketConnect},
+		{"errorsSocketSetSockOpt", s.errorsSocketSetSockOpt},
+		{"errorsSocketWrite", s.errorsSocketWrite},
+		{"errorsUnexpectedHttpCode", s.errorsUnexpectedHttpCode},
+		{"errorsRetried", s.errorsRetried},
+	}
+}
+
+// Total number of errors of all kinds.
+func (s *stats) errors() (n uint) {
+	for _, c := range s.errorBreakdown() {
+		n += c.count
+	}
+	return
+}
+
+// The counters accumulated in s since prev, an earlier snapshot of the same stats. Max is not tracked per interval,
+// so the histogram should be used instead.
+func (s *stats) since(prev *stats) (d *stats) {
+	d = newStats()
+	d.add(s)
+	d.max = 0
+	d.reqsStarted -= prev.reqsStarted
+	d.reqsWritten -= prev.reqsWritten
+	d.respRecvd -= prev.respRecvd
+	d.errorsTooManyConcurrent -= prev.errorsTooManyConcurrent
+	d.errorsResponseReader -= prev.errorsResponseReader
+	d.errorsNoResponse -= prev.errorsNoResponse
+	d.errorsTimeout -= prev.errorsTimeout
+	d.errorsConnectTimeout -= prev.errorsConnectTimeout
+	d.errorsFirstByteTimeout -= prev.errorsFirstByteTimeout
+	d.errorsSocketCreate -= prev.errorsSocketCreate
+	d.errorsSocketSetSockOpt -= prev.errorsSocketSetSockOpt
+	d.errorsSocketConnect -= prev.errorsSocketConnect
+	d.errorsSocketWrite -= prev.errorsSocketWrite
+	d.errorsUnexpectedHttpCode -= prev.errorsUnexpectedHttpCode
+	d.errorsRetried -= prev.errorsRetried
+	d.retries -= prev.retries
+	for i := 0; i < len(prev.errorReasons); i++ {
+		d.errorReasons[i] -= prev.errorReasons[i]
+	}
+	for i := 0; i < len(prev.httpCodes); i++ {
+		d.httpCodes[i] -= prev.httpCodes[i]
+	}
+	d.connsOpened -= prev.connsOpened
+	d.connsClosed -= prev.connsClosed
+	if d.hist != nil && prev.hist != nil {
+		d.hist.subtract(prev.hist)
+	}
+	if d.sendLag != nil && prev.sendLag != nil {
+		d.sendLag.subtract(prev.sendLag)
+	}
+	return
+}
+
+// Accumulates the counters of o into s.
+func (s *stats) add(o *stats) {
+	s.reqsStarted += o.reqsStarted
+	s.reqsWritten += o.reqsWritten
+	s.respRecvd += o.respRecvd
+	s.errorsTooManyConcurrent += o.errorsTooManyConcurrent
+	s.errorsResponseReader += o.errorsResponseReader
+	s.errorsNoResponse += o.errorsNoResponse
+	s.errorsTimeout += o.errorsTimeout
+	s.errorsConnectTimeout += o.errorsConnectTimeout
+	s.errorsFirstByteTimeout += o.errorsFirstByteTimeout
+	s.errorsSocketCreate += o.errorsSocketCreate
+	s.errorsSocketSetSockOpt += o.errorsSocketSetSockOpt
+	s.errorsSocketConnect += o.errorsSocketConnect
+	s.errorsSocketWrite += o.errorsSocketWrite
+	s.errorsUnexpectedHttpCode += o.errorsUnexpectedHttpCode
+	s.errorsRetried += o.errorsRetried
+	s.retries += o.retries
+	for i := 0; i < len(o.errorReasons); i++ {
+		s.errorReasons[i] += o.errorReasons[i]
+	}
+	for i := 0; i < len(o.httpCodes); i++ {
+		s.httpCodes[i] += o.httpCodes[i]
+	}
+	s.connsOpened += o.connsOpened
+	s.connsClosed += o.connsClosed
+	if o.max > s.max {
+		s.max = o.max
+	}
+	if o.hist != nil {
+		if s.hist == nil {
+			s.hist = o.hist.newEmptyCopy()
+		}
+		s.hist.merge(o.hist)
+	}
+	if o.sendLag != nil {
+		if s.sendLag == nil {
+			s.sendLag = o.sendLag.newEmptyCopy()
+		}
+		s.sendLag.merge(o.sendLag)
+	}
+}
+
+// Makes s a copy of o, reusing the histograms of s if it has them.
+func (s *stats) copyFrom(o *stats) {
+	hist, sendLag := s.hist, s.sendLag
+	*s = *o
+	s.hist, s.sendLag = nil, nil
+	if o.hist != nil {
+		s.hist = hist
+		if s.hist == nil {
+			s.hist = o.hist.newEmptyCopy()
+		}
+		s.hist.reset()
+		s.hist.merge(o.hist)
+	}
+	if o.sendLag != nil {
+		s.sendLag = sendLag
+		if s.sendLag == nil {
+			s.sendLag = o.sendLag.newEmptyCopy()
+		}
+		s.sendLag.reset()
+		s.sendLag.merge(o.sendLag)
+	}
 }
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A run of benchmarks at a fixed list of rps values, to map out latency against throughput.
+type sweep struct {
+	rpss           []int   // The rps values to run, in increasing order.
+	repeats        int     // Number of benchmarks per rps value.
+	kneePercentile float64 // The latency percentile that the knee of the curve is detected on.
+}
+
+// The aggregated results of the benchmarks at one rps value of a sweep.
+type sweepPoint struct {
+	rps       int
+	recvdRate float64             // Mean achieved throughput across repeats.
+	errors    uint                // Total errors across repeats.
+	latencyMs map[float64]float64 // Median across repeats of the latency at each percentile, with 100 being the max.
+}
+
+// Parses a comma separated list of rps values and ranges, such as "100,200,500:5000:500". A range is written as
+// start:end:step and includes the end if the steps land on it. The values are returned sorted and without duplicates,
+// as the knee and the chart take the curve to be in order of rps.
+func parseSweep(s string) (rpss []int, err error) {
+	for _, text := range strings.Split(s, ",") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		parts := strings.Split(text, ":")
+		nums := make([]int, len(parts))
+		for i, part := range parts {
+			nums[i], err = strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || nums[i] < 1 {
+				err = fmt.Errorf("invalid rps %q in %q", part, text)
+				return
+			}
+		}
+
+		switch len(nums) {
+		case 1:
+			rpss = append(rpss, nums[0])
+		case 3:
+			if nums[1] < nums[0] {
+				err = fmt.Errorf("range %q ends before it starts", text)
+				return
+			}
+			for rps := nums[0]; rps <= nums[1]; rps += nums[2] {
+				rpss = append(rpss, rps)
+			}
+		default:
+			err = fmt.Errorf("invalid range %q, expected start:end:step", text)
+			return
+		}
+	}
+
+	if len(rpss) == 0 {
+		err = fmt.Errorf("sweep has no rps values")
+		return
+	}
+
+	sort.Ints(rpss)
+	unique := rpss[:1]
+	for _, rps := range rpss[1:] {
+		if rps != unique[len(unique)-1] {
+			unique = append(unique, rps)
+		}
+	}
+	rpss = unique
+	return
+}
+
+// Summarizes the results of the repeats at one rps value.
+func newSweepPoint(rps int, results []BenchmarkResult, percentiles []float64) (p sweepPoint) {
+	p.rps = rps
+	p.latencyMs = make(map[float64]float64)
+
+	for _, r := range results {
+		p.recvdRate += r.recvdRate / float64(len(results))
+		p.errors += r.errors
+	}
+
+	for _, pct := range withMax(percentiles) {
+		vals := make([]float64, 0, len(results))
+		for i := range results {
+			vals = append(vals, float64(results[i].latencyAtPercentile(pct))/float64(time.Millisecond))
+		}
+		sort.Float64s(vals)
+		p.latencyMs[pct] = vals[len(vals)/2]
+	}
+
+	return
+}
+
+// The percentiles below 100, followed by 100 for the max.
+func withMax(percentiles []float64) (ps []float64) {
+	for _, p := range percentiles {
+		if p < 100 {
+			ps = append(ps, p)
+		}
+	}
+	ps = append(ps, 100)
+	return
+}
+
+// Finds the knee of an increasing curve, using the Kneedle method: both axes are normalized to [0, 1], and the
+// knee is the point that lies furthest below the straight line from the first to the last point. Returns false
+// if the curve has fewer than three points or does not bend upwards.
+func findKnee(xs []float64, ys []float64) (knee int, ok bool) {
+	if len(xs) < 3 || len(xs) != len(ys) {
+		return
+	}
+
+	xMin, xMax := xs[0], xs[len(xs)-1]
+	yMin, yMax := ys[0], ys[0]
+	for _, y := range ys {
+		yMin = math.Min(yMin, y)
+		yMax = math.Max(yMax, y)
+	}
+	if xMax <= xMin || yMax <= yMin {
+		return
+	}
+
+	best := 0.0
+	for i := range xs {
+		xn := (xs[i] - xMin) / (xMax - xMin)
+		yn := (ys[i] - yMin) / (yMax - yMin)
+		if d := xn - yn; d > best {
+			best = d
+			knee = i
+			ok = true
+		}
+	}
+
+	return
+}
+
+// Writes the header of sweep.csv, with a column for each percentile and each kind of error.
+func writeSweepHeader(filename string, percentiles []float64) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "rps,repeat,pass,startedRate,recvdRate,recvd,errors")
+	for _, p := range percentiles {
+		if p >= 100 {
+			continue // Written as maxms.
+		}
+		fmt.Fprintf(w, ",%sms", percentileName(p))
+	}
+	fmt.Fprintf(w, ",maxms")
+	for _, c := range (&stats{}).errorBreakdown() {
+		fmt.Fprintf(w, ",%s", c.name)
+	}
+	fmt.Fprintf(w, "\n")
+
+	err = w.Flush()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
+
+// Appends the result of one benchmark of the sweep to sweep.csv.
+func appendSweepRow(filename string, rps int, repeat int, r *BenchmarkResult) (err error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	pass := 0
+	if r.sloPass {
+		pass = 1
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "%d,%d,%d,%f,%f,%d,%d", rps, repeat, pass, r.startedRate, r.recvdRate, r.recvd, r.errors)
+	for _, p := range r.percentiles {
+		if p >= 100 {
+			continue
+		}
+		fmt.Fprintf(w, ",%f", float64(r.latencyAtPercentile(p))/float64(time.Millisecond))
+	}
+	fmt.Fprintf(w, ",%f", float64(r.max)/float64(time.Millisecond))
+	for _, c := range r.stats.errorBreakdown() {
+		fmt.Fprintf(w, ",%d", c.count)
+	}
+	fmt.Fprintf(w, "\n")
+
+	err = w.Flush()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
+
+// Writes an SVG chart of the sweep. The upper panel shows latency at each percentile against the target rps on a
+// log scale, and the lower panel shows achieved against target rps. The knee, if any, is marked in both panels.
+func writeSweepChart(filename string, points []sweepPoint, percentiles []float64, knee int, kneeFound bool) (err error) {
+	const (
+		top         = 40
+		panelHeight = 300
+		gap         = 70
+		bottom      = 50
+		height      = top + 2*panelHeight + gap + bottom
+	)
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	xMax := 0.0
+	yMin, yMax := math.Inf(1), 0.0
+	for _, p := range points {
+		xMax = math.Max(xMax, float64(p.rps))
+		xMax = math.Max(xMax, p.recvdRate)
+		for _, v := range p.latencyMs {
+			if v > 0 {
+				yMin = math.Min(yMin, v)
+			}
+			yMax = math.Max(yMax, v)
+		}
+	}
+
+	latency := &svgChart{w: w, width: 900, height: height, left: 80, right: 140, top: top, bottom: height - top - panelHeight, xMax: xMax, logY: true}
+	throughput := *latency
+	throughput.top = top + panelHeight + gap
+	throughput.bottom = bottom
+	throughput.yMax = xMax
+	throughput.logY = false
+	latency.open()
+	if xMax == 0 || yMax == 0 {
+		latency.end()
+		err = w.Flush()
+		return
+	}
+	latency.yMin, latency.yMax = decades(yMin, yMax)
+	latency.axes("Latency by target rps", formatCount, formatMs)
+	throughput.axes("Achieved rps by target rps", formatCount, formatCount)
+
+	// Latency lines, one per percentile, with the max last.
+	var xs []float64
+	for _, p := range points {
+		xs = append(xs, float64(p.rps))
+	}
+	for i, pct := range withMax(percentiles) {
+		color := chartColors[i%len(chartColors)]
+		var ys []float64
+		for _, p := range points {
+			ys = append(ys, p.latencyMs[pct])
+		}
+		latency.polyline(color, xs, ys)
+
+		name := percentileName(pct)
+		if pct == 100 {
+			name = "max"
+		}
+		latency.legend(i, color, name)
+	}
+
+	// Achieved throughput, against the ideal of achieving the target.
+	fmt.Fprintf(w, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"#999\" stroke-dasharray=\"4,4\"/>\n", throughput.x(0), throughput.y(0), throughput.x(xMax), throughput.y(xMax))
+	var achieved []float64
+	for _, p := range points {
+		achieved = append(achieved, p.recvdRate)
+	}
+	throughput.polyline(chartColors[0], xs, achieved)
+
+	if kneeFound {
+		kx := latency.x(float64(points[knee].rps))
+		fmt.Fprintf(w, "<line x1=\"%.1f\" y1=\"%d\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"red\" stroke-dasharray=\"6,3\"/>\n", kx, top, kx, throughput.y(0))
+		fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%d\" fill=\"red\">knee: %d rps</text>\n", kx+5, top+12, points[knee].rps)
+	}
+
+	latency.end()
+
+	err = w.Flush()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSweep(t *testing.T) {
+	// Arrange
+	s := "100, 200,500:1000:250,2000:2500:1000"
+
+	// Act
+	rpss, err := parseSweep(s)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []int{100, 200, 500, 750, 1000, 2000}
+	if !reflect.DeepEqual(rpss, expected) {
+		t.Fatalf("Unexpected rps values: %v", rpss)
+	}
+}
+
+func TestParseSweepInvalid(t *testing.T) {
+	for _, s := range []string{"", "abc", "0", "100:50:10", "100:200", "100:200:0"} {
+		// Act
+		_, err := parseSweep(s)
+
+		// Assert
+		if err == nil {
+			t.Fatalf("Unexpected success for %q", s)
+		}
+	}
+}
+
+func TestFindKnee(t *testing.T) {
+	// Arrange
+	xs := []float64{100, 200, 300, 400, 500, 600}
+	ys := []float64{10, 10.5, 11, 12, 100, 200}
+
+	// Act
+	knee, ok := findKnee(xs, ys)
+
+	// Assert
+	if !ok {
+		t.Fatalf("Unexpected no knee")
+	}
+	if knee != 3 {
+		t.Fatalf("Unexpected knee: %d", knee)
+	}
+}
+
+func TestFindKneeFlat(t *testing.T) {
+	// Arrange
+	xs := []float64{100, 200, 300}
+	ys := []float64{10, 10, 10}
+
+	// Act
+	_, ok := findKnee(xs, ys)
+
+	// Assert
+	if ok {
+		t.Fatalf("Unexpected knee in flat curve")
+	}
+}
+
+func TestParseSweepSortsAndDeduplicates(t *testing.T) {
+	// Arrange
+	s := "500,100,100,200:400:100"
+
+	// Act
+	rpss, err := parseSweep(s)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []int{100, 200, 300, 400, 500}
+	if !reflect.DeepEqual(rpss, expected) {
+		t.Fatalf("Unexpected rps values: %v", rpss)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"container/heap"
+	"sync/atomic"
+	"time"
+)
+
+// The deadlines a request can run past.
+type timeoutKind int
+
+const (
+	timeoutTotal     timeoutKind = iota // From the planned time of the request until the full response arrived.
+	timeoutConnect                      // From starting to connect until the connection was established.
+	timeoutFirstByte                    // From the full request being written until the first byte of the response arrived.
+)
+
+// A point in time by which a request must have gotten past some phase.
+type deadline struct {
+	at      time.Duration // Time since the beginning of the benchmark.
+	req     *request
+	kind    timeoutKind
+	attempt int // The attempt of the request that the deadline applies to, as retries get connect and first byte deadlines of their own.
+}
+
+// Pending deadlines of the requests of a worker, soonest first. Deadlines of requests that have since finished are
+// left in place and skipped when they come up.
+type timeoutHeap []deadline
+
+func (h timeoutHeap) Len() int           { return len(h) }
+func (h timeoutHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h timeoutHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *timeoutHeap) Push(x interface{}) {
+	*h = append(*h, x.(deadline))
+}
+
+func (h *timeoutHeap) Pop() interface{} {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
+
+// Whether the deadline still applies, that is the request has not finished and not gotten past the phase.
+func (d deadline) pending() bool {
+	r := d.req
+	if r.completed || r.error {
+		return false
+	}
+
+	switch d.kind {
+	case timeoutConnect:
+		return r.attempts == d.attempt && r.connectedAt == 0
+	case timeoutFirstByte:
+		return r.attempts == d.attempt && r.firstByteAt == 0
+	}
+	return true
+}
+
+// Adds a deadline for a request, and rearms the timeout timer if it is now the soonest.
+func (b *benchmarkWorker) addDeadline(r *request, kind timeoutKind, at time.Duration) (err error) {
+	heap.Push(&b.timeouts, deadline{at: at, req: r, kind: kind, attempt: r.attempts})
+	if b.timeouts[0].req == r && b.timeouts[0].kind == kind && b.timeouts[0].at == at {
+		err = b.armTimeoutTimer()
+	}
+	return
+}
+
+// Sets the timeout timer to trigger at the soonest deadline, or disarms it if there is none.
+func (b *benchmarkWorker) armTimeoutTimer() (err error) {
+	if len(b.timeouts) == 0 {
+		err = timerFdSetTime(0, b.timerfdTimeout)
+		return
+	}
+
+	timeUntilTimeout := b.timeouts[0].at - time.Since(b.benchmark.startTime)
+	if timeUntilTimeout < 1 {
+		timeUntilTimeout = 1 // 0 means never trigger, so use 1 nanosecond instead.
+	}
+	err = timerFdSetTime(timeUntilTimeout, b.timerfdTimeout)
+	return
+}
+
+func (b *benchmarkWorker) handleTimeoutTimerTriggered() (err error) {
+	now := time.Since(b.benchmark.startTime)
+	for len(b.timeouts) > 0 && b.timeouts[0].at <= now {
+		d := heap.Pop(&b.timeouts).(deadline)
+		if !d.pending() {
+			continue
+		}
+
+		err = b.timeoutRequest(d.req, d.kind)
+		if err != nil {
+			return
+		}
+	}
+
+	err = b.armTimeoutTimer()
+	return
+}
+
+// Fails a request that ran past one of its deadlines, recording the time it actually took.
+func (b *benchmarkWorker) timeoutRequest(r *request, kind timeoutKind) (err error) {
+	reason := r.timeoutReason()
+	if r.queued {
+		// The request never got a concurrency slot. It is dropped from the queue lazily by issueQueuedRequests.
+		r.queued = false
+		atomic.AddInt64(&b.benchmark.reqsQueued, -1)
+		reason = reasonTotalTimeoutQueued
+	} else {
+		b.releaseSlot(r)
+		err = b.abandonConn(r.socketfd, r)
+		if err != nil {
+			return
+		}
+		r.socketfd = 0
+		r.responseReader = ResponseReader{}
+	}
+
+	switch kind {
+	case timeoutTotal:
+		b.fail(r, reason)
+		b.statsFor(r).errorsTimeout++
+	case timeoutConnect:
+		b.fail(r, reasonConnectTimeout)
+		b.statsFor(r).errorsConnectTimeout++
+	case timeoutFirstByte:
+		b.fail(r, reasonFirstByteTimeout)
+		b.statsFor(r).errorsFirstByteTimeout++
+	}
+
+	r.completedAt = time.Since(b.benchmark.startTime)
+	r.responseTime = r.completedAt - r.when
+	b.statsFor(r).recordLatency(r.responseTime)
+	return
+}
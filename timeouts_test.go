@@ -0,0 +1,84 @@
+package main
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestTimeoutHeapOrder(t *testing.T) {
+	// Arrange
+	var h timeoutHeap
+	r := &request{}
+	for _, at := range []int{30, 10, 20, 50, 40} {
+		heap.Push(&h, deadline{at: time.Duration(at) * time.Millisecond, req: r})
+	}
+
+	// Act
+	var got []float64
+	for h.Len() > 0 {
+		got = append(got, durationMs(heap.Pop(&h).(deadline).at))
+	}
+
+	// Assert
+	for i, want := range []float64{10, 20, 30, 40, 50} {
+		if got[i] != want {
+			t.Fatalf("Unexpected order: %v", got)
+		}
+	}
+}
+
+func TestDeadlinePending(t *testing.T) {
+	// Arrange
+	r := &request{attempts: 1}
+	total := deadline{req: r, kind: timeoutTotal, attempt: 1}
+	connect := deadline{req: r, kind: timeoutConnect, attempt: 1}
+	firstByte := deadline{req: r, kind: timeoutFirstByte, attempt: 1}
+
+	// Act & Assert
+	if !total.pending() || !connect.pending() || !firstByte.pending() {
+		t.Fatalf("Unexpected deadline not pending")
+	}
+
+	r.connectedAt = 1
+	if connect.pending() {
+		t.Fatalf("Unexpected connect deadline pending after connecting")
+	}
+
+	r.attempts = 2
+	r.connectedAt = 0
+	if connect.pending() || firstByte.pending() {
+		t.Fatalf("Unexpected deadline of an earlier attempt pending")
+	}
+	if !total.pending() {
+		t.Fatalf("Unexpected total deadline not pending after a retry")
+	}
+
+	r.completed = true
+	if total.pending() {
+		t.Fatalf("Unexpected total deadline pending after completing")
+	}
+}
+
+func TestTimeoutRequestIsNotReceived(t *testing.T) {
+	// Arrange
+	w := newSlotTestWorker(1, concurrencyModeQueue)
+	w.benchmark.startTime = time.Now().Add(-time.Second)
+	w.stats.hist = newHistogram(histogramLowestMicros, histogramHighestMicros, 3)
+	w.benchmark.reqsQueued = 1
+	r := &request{attempts: 1, queued: true}
+
+	// Act
+	err := w.timeoutRequest(r, timeoutTotal)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if w.stats.respRecvd != 0 || w.stats.errorsTimeout != 1 {
+		t.Fatalf("Unexpected respRecvd %d or errorsTimeout %d", w.stats.respRecvd, w.stats.errorsTimeout)
+	}
+	if w.stats.hist.totalCount != 1 || w.stats.max < time.Second {
+		t.Fatalf("Unexpected latency count %d or max %v", w.stats.hist.totalCount, w.stats.max)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The counters of a benchmark over one interval of about a second, for lining up latency spikes with events on
+// the server side.
+type timeSeriesSample struct {
+	Time            float64            `json:"time"`            // Wall clock time at the end of the interval, in seconds since the epoch.
+	Rps             int                `json:"rps"`             // Target rps of the benchmark.
+	Second          float64            `json:"second"`          // Time since the start of the benchmark at the end of the interval.
+	IntervalSeconds float64            `json:"intervalSeconds"` // Length of the interval.
+	Warmup          bool               `json:"warmup"`
+	Started         uint               `json:"started"`
+	Written         uint               `json:"written"`
+	Recvd           uint               `json:"recvd"`
+	Errors          map[string]uint    `json:"errors"`     // Errors by kind.
+	Concurrent      int64              `json:"concurrent"` // Requests in flight at the end of the interval.
+	Queued          int64              `json:"queued"`     // Requests waiting for a concurrency slot at the end of the interval.
+	ConnsAlive      int                `json:"connsAlive"` // Connections open at the end of the interval.
+	ConnsOpened     uint               `json:"connsOpened"`
+	ConnsClosed     uint               `json:"connsClosed"`
+	LatencyMs       map[string]float64 `json:"latencyMs"` // Latency of the requests that finished in the interval, by percentile name and "max".
+	Host            *hostInterval      `json:"host"`      // Load on the client host, or nil if /proc could not be read.
+}
+
+// Whether samples are written as JSON lines rather than CSV, as chosen by the file extension.
+func timeSeriesIsJSON(filename string) bool {
+	return strings.HasSuffix(filename, ".json") || strings.HasSuffix(filename, ".jsonl")
+}
+
+// Creates or truncates the time series file, and writes the CSV header unless the file is JSON lines.
+func createTimeSeriesFile(filename string, percentiles []float64) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if !timeSeriesIsJSON(filename) {
+		w := bufio.NewWriter(f)
+		fmt.Fprintf(w, "time,rps,second,intervalSeconds,warmup,started,written,recvd,errors")
+		for _, c := range (&stats{}).errorBreakdown() {
+			fmt.Fprintf(w, ",%s", c.name)
+		}
+		fmt.Fprintf(w, ",concurrent,queued,connsAlive,connsOpened,connsClosed")
+		fmt.Fprintf(w, ",cpuBusyPct,cpuMaxCoreBusyPct,cpuMaxCoreSoftirqPct,ctxSwitches,tcpRetransSegs,listenDrops,sockets,tcpInUse,tcpTimeWait")
+		for _, p := range percentiles {
+			if p >= 100 {
+				continue // Written as maxms.
+			}
+			fmt.Fprintf(w, ",%sms", percentileName(p))
+		}
+		fmt.Fprintf(w, ",maxms\n")
+
+		err = w.Flush()
+		if err != nil {
+			return
+		}
+	}
+
+	err = f.Close()
+	return
+}
+
+// Appends samples to the time series file created by createTimeSeriesFile.
+func appendTimeSeries(filename string, samples []timeSeriesSample, percentiles []float64) (err error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if timeSeriesIsJSON(filename) {
+		enc := json.NewEncoder(w)
+		for i := range samples {
+			err = enc.Encode(&samples[i])
+			if err != nil {
+				return
+			}
+		}
+	} else {
+		for _, s := range samples {
+			warmup := 0
+			if s.Warmup {
+				warmup = 1
+			}
+
+			var errors uint
+			for _, n := range s.Errors {
+				errors += n
+			}
+
+			fmt.Fprintf(w, "%.3f,%d,%.3f,%.3f,%d,%d,%d,%d,%d", s.Time, s.Rps, s.Second, s.IntervalSeconds, warmup, s.Started, s.Written, s.Recvd, errors)
+			for _, c := range (&stats{}).errorBreakdown() {
+				fmt.Fprintf(w, ",%d", s.Errors[c.name])
+			}
+			fmt.Fprintf(w, ",%d,%d,%d,%d,%d", s.Concurrent, s.Queued, s.ConnsAlive, s.ConnsOpened, s.ConnsClosed)
+			if h := s.Host; h != nil {
+				fmt.Fprintf(w, ",%.1f,%.1f,%.1f,%d,%d,%d,%d,%d,%d", h.cpuBusyPct(), h.maxCoreBusyPct(), h.maxCoreSoftirqPct(),
+					h.CtxSwitches, h.TCPRetransSegs, h.ListenDrops, h.Sockets, h.TCPInUse, h.TCPTimeWait)
+			} else {
+				fmt.Fprintf(w, ",,,,,,,,,")
+			}
+			for _, p := range percentiles {
+				if p >= 100 {
+					continue
+				}
+				fmt.Fprintf(w, ",%f", s.LatencyMs[percentileName(p)])
+			}
+			fmt.Fprintf(w, ",%f\n", s.LatencyMs["max"])
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	return
+}
+
+// Samples the counters of all workers, measured and warmup alike, since the previous sample.
+func (b *Benchmark) recordInterval() {
+	s, connsAlive := b.mergeSnapshots(true, true)
+
+	elapsed := b.elapsed()
+	d := s
+	if b.prevIntervalStats != nil {
+		d = s.since(b.prevIntervalStats)
+	}
+
+	sample := timeSeriesSample{
+		Time:            float64(b.startTime.Add(elapsed).UnixNano()) / float64(time.Second),
+		Rps:             b.rps,
+		Second:          elapsed.Seconds(),
+		IntervalSeconds: (elapsed - b.prevIntervalEnd).Seconds(),
+		Warmup:          b.prevIntervalEnd < time.Duration(b.warmupSeconds)*time.Second,
+		Started:         d.reqsStarted,
+		Written:         d.reqsWritten,
+		Recvd:           d.respRecvd,
+		Errors:          make(map[string]uint),
+		Concurrent:      atomic.LoadInt64(&b.reqsInFlight),
+		Queued:          atomic.LoadInt64(&b.reqsQueued),
+		ConnsAlive:      connsAlive,
+		ConnsOpened:     d.connsOpened,
+		ConnsClosed:     d.connsClosed,
+		LatencyMs:       make(map[string]float64),
+	}
+	for _, c := range d.errorBreakdown() {
+		sample.Errors[c.name] = c.count
+	}
+	for _, p := range b.percentiles {
+		if p < 100 {
+			sample.LatencyMs[percentileName(p)] = float64(d.hist.valueAtPercentile(p)) / 1000
+		}
+	}
+	sample.LatencyMs["max"] = float64(d.hist.valueAtPercentile(100)) / 1000
+
+	if b.prevHost != nil {
+		host, err := readHostCounters()
+		if err == nil {
+			h := host.since(b.prevHost)
+			sample.Host = &h
+			b.prevHost = host
+		}
+	}
+
+	b.intervals = append(b.intervals, sample)
+	b.prevIntervalStats = s
+	liveMetrics.intervalRecorded(s, sample.Concurrent, sample.Queued, connsAlive, b.inWarmup())
+	b.prevIntervalEnd = elapsed
+}